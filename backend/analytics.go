@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	analyticsDefaultDays  = 30
+	analyticsMaxDays      = 366
+	analyticsDefaultLimit = 20
+	analyticsMaxLimit     = 500
+)
+
+// requisitionClosure is a complete or reject event, with the creation time of the requisition it closed
+type requisitionClosure struct {
+	requisitionEvent
+	RequestedAt time.Time
+}
+
+type analyticsTypeDemand struct {
+	TypeId    int32  `json:"type_id"`
+	TypeName  string `json:"type_name,omitempty"`
+	Requests  int32  `json:"requests"`  // requisitions including the type
+	Requested int32  `json:"requested"` // copies requested
+	Delivered int32  `json:"delivered"` // copies handed over on completed requisitions
+}
+
+// analyticsLatency summarises time from creation to close in seconds
+type analyticsLatency struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+type analyticsWorker struct {
+	CharacterId        int32            `json:"character_id"`
+	CharacterName      string           `json:"character_name"`
+	Completed          int32            `json:"completed"`
+	PartiallyCompleted int32            `json:"partially_completed"`
+	Rejected           int32            `json:"rejected"`
+	RejectionRate      float64          `json:"rejection_rate"`
+	Latency            analyticsLatency `json:"latency"`
+
+	latencies []time.Duration
+}
+
+type analyticsResponse struct {
+	From      time.Time             `json:"from"`
+	To        time.Time             `json:"to"`
+	Created   int                   `json:"created"`   // requisitions created in the window
+	Statuses  map[string]int        `json:"statuses"`  // current status of requisitions created in the window
+	Demand    []analyticsTypeDemand `json:"demand"`    // top requested types, see ?limit
+	Latency   analyticsLatency      `json:"latency"`   // completions in the window
+	Rejection float64               `json:"rejection"` // share of closures in the window which were rejections
+	Workers   []analyticsWorker     `json:"workers"`
+}
+
+// getAnalytics aggregates requisition demand and fulfilment over a window, set with ?from and ?to
+// as dates or RFC3339 timestamps. Defaults to the last 30 days.
+func (app *app) getAnalytics(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+	query := r.URL.Query()
+
+	to, err := parseTimeParam(query.Get("to"), time.Now())
+	if err != nil {
+		httpError(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.AddDate(0, 0, -analyticsDefaultDays))
+	if err != nil {
+		httpError(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) || to.Sub(from) > analyticsMaxDays*24*time.Hour {
+		httpError(w, "invalid window", http.StatusBadRequest)
+		return
+	}
+
+	limit := analyticsDefaultLimit
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > analyticsMaxLimit {
+			httpError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reqs, err := app.dao.listRequisitionsCreatedBetween(from, to)
+	if err != nil {
+		logger.Error("error listing requisitions", zap.Error(err))
+		httpError(w, "error listing requisitions", http.StatusInternalServerError)
+		return
+	}
+
+	closures, err := app.dao.listRequisitionClosures(from, to)
+	if err != nil {
+		logger.Error("error listing requisition events", zap.Error(err))
+		httpError(w, "error listing requisition events", http.StatusInternalServerError)
+		return
+	}
+
+	resp := analyticsResponse{
+		From:     from,
+		To:       to,
+		Created:  len(reqs),
+		Statuses: map[string]int{},
+		Demand:   app.analyticsDemand(reqs, limit),
+		Workers:  []analyticsWorker{},
+	}
+	for _, req := range reqs {
+		resp.Statuses[req.Status.String()]++
+	}
+
+	var latencies []time.Duration
+	var rejected int
+	workers := map[int32]*analyticsWorker{}
+	for _, c := range closures {
+		worker, ok := workers[c.CharacterId]
+		if !ok {
+			worker = &analyticsWorker{CharacterId: c.CharacterId, CharacterName: c.CharacterName}
+			if c.CharacterId == 0 {
+				worker.CharacterName = "contracts" // see syncCorpContracts
+			}
+			workers[c.CharacterId] = worker
+		}
+
+		switch c.NewStatus {
+		case requisitionStatus_Rejected:
+			worker.Rejected++
+			rejected++
+			continue
+		case requisitionStatus_PartiallyCompleted:
+			worker.PartiallyCompleted++
+		default:
+			worker.Completed++
+		}
+
+		latency := c.CreatedAt.Sub(c.RequestedAt)
+		latencies = append(latencies, latency)
+		worker.latencies = append(worker.latencies, latency)
+	}
+
+	resp.Latency = newAnalyticsLatency(latencies)
+	if len(closures) > 0 {
+		resp.Rejection = float64(rejected) / float64(len(closures))
+	}
+
+	for _, worker := range workers {
+		worker.Latency = newAnalyticsLatency(worker.latencies)
+		closed := worker.Completed + worker.PartiallyCompleted + worker.Rejected
+		worker.RejectionRate = float64(worker.Rejected) / float64(closed)
+		resp.Workers = append(resp.Workers, *worker)
+	}
+	slices.SortFunc(resp.Workers, func(a, b analyticsWorker) int {
+		return cmp.Or(
+			cmp.Compare(b.Completed+b.PartiallyCompleted, a.Completed+a.PartiallyCompleted),
+			cmp.Compare(a.CharacterId, b.CharacterId))
+	})
+
+	httpWrite(w, resp)
+}
+
+// analyticsDemand totals the copies requested per type, returning the limit most requested
+func (app *app) analyticsDemand(reqs []requisitionOrder, limit int) []analyticsTypeDemand {
+	demand := map[int32]*analyticsTypeDemand{}
+	for _, req := range reqs {
+		seen := map[int32]bool{}
+		for _, bp := range req.Blueprints {
+			d, ok := demand[bp.TypeId]
+			if !ok {
+				d = &analyticsTypeDemand{TypeId: bp.TypeId, TypeName: bp.Name}
+				demand[bp.TypeId] = d
+			}
+			if !seen[bp.TypeId] {
+				d.Requests++
+				seen[bp.TypeId] = true
+			}
+			d.Requested += bp.count()
+
+			switch req.Status {
+			case requisitionStatus_Completed, requisitionStatus_PartiallyCompleted:
+				d.Delivered += bp.allocated()
+			}
+		}
+	}
+
+	app.invStateLock.RLock()
+	resp := make([]analyticsTypeDemand, 0, len(demand))
+	for _, d := range demand {
+		if name, ok := app.inventoryState.typeNames[d.TypeId]; ok {
+			d.TypeName = name
+		}
+		resp = append(resp, *d)
+	}
+	app.invStateLock.RUnlock()
+
+	slices.SortFunc(resp, func(a, b analyticsTypeDemand) int {
+		return cmp.Or(
+			cmp.Compare(b.Requested, a.Requested),
+			cmp.Compare(b.Requests, a.Requests),
+			cmp.Compare(a.TypeId, b.TypeId))
+	})
+	return resp[:min(limit, len(resp))]
+}
+
+func newAnalyticsLatency(latencies []time.Duration) analyticsLatency {
+	if len(latencies) == 0 {
+		return analyticsLatency{}
+	}
+
+	sorted := slices.Sorted(slices.Values(latencies))
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	return analyticsLatency{
+		Count: len(sorted),
+		Mean:  (total / time.Duration(len(sorted))).Seconds(),
+		P50:   percentile(sorted, 50).Seconds(),
+		P90:   percentile(sorted, 90).Seconds(),
+		P95:   percentile(sorted, 95).Seconds(),
+		P99:   percentile(sorted, 99).Seconds(),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -4,9 +4,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -24,22 +25,54 @@ func (app *app) createApiHandlers(mux *http.ServeMux, mw *mwChain) {
 	mux.Handle("GET /api/blueprints", authChain.HandleFunc(app.getBlueprints))
 
 	mux.Handle("POST /api/requisition", authChain.HandleFunc(app.postRequisitionOrder))
+	mux.Handle("POST /api/requisition/bulk", workerChain.HandleFunc(app.postBulkRequisitionAction))
+	mux.Handle("POST /api/requisition/import", authChain.HandleFunc(app.importRequisition))
 	mux.Handle("GET /api/requisition", authChain.HandleFunc(app.listRequisitionOrders))
 	mux.Handle("GET /api/requisition/{id}", authChain.HandleFunc(app.getRequisitionOrder))
+	mux.Handle("PUT /api/requisition/{id}", authChain.HandleFunc(app.putRequisitionOrder))
+	mux.Handle("GET /api/requisition/{id}/versions", authChain.HandleFunc(app.getRequisitionVersions))
+	mux.Handle("GET /api/requisition/{id}/history", authChain.HandleFunc(app.getRequisitionHistory))
+	mux.Handle("GET /api/requisition/{id}/picklist", workerChain.HandleFunc(app.getRequisitionPickList))
+	mux.Handle("GET /api/requisition/{id}/comments", authChain.HandleFunc(app.getRequisitionComments))
+	mux.Handle("POST /api/requisition/{id}/comments", authChain.HandleFunc(app.postRequisitionComment))
 	mux.Handle("PATCH /api/requisition/{id}/cancel", authChain.HandleFunc(app.patchRequisitionOrder))
 	mux.Handle("PATCH /api/requisition/{id}/{action}", workerChain.HandleFunc(app.patchRequisitionOrder))
 
+	mux.Handle("GET /api/locks", adminChain.HandleFunc(app.listRequisitionLocks))
+	mux.Handle("DELETE /api/locks/{id}", adminChain.HandleFunc(app.deleteRequisitionLock))
+
+	mux.Handle("GET /api/contracts", workerChain.HandleFunc(app.listRequisitionContracts))
+	mux.Handle("GET /api/industry/jobs", workerChain.HandleFunc(app.listIndustryJobs))
+	mux.Handle("GET /api/planner", workerChain.HandleFunc(app.getPlanner))
+	mux.Handle("GET /api/inventory/changes", workerChain.HandleFunc(app.listInventoryChanges))
+
+	mux.Handle("GET /api/stock/targets", workerChain.HandleFunc(app.listStockTargets))
+	mux.Handle("PUT /api/stock/targets", adminChain.HandleFunc(app.putStockTarget))
+	mux.Handle("DELETE /api/stock/targets/{id}", adminChain.HandleFunc(app.deleteStockTarget))
+	mux.Handle("GET /api/stock/shortfall", workerChain.HandleFunc(app.getStockShortfall))
+
+	mux.Handle("GET /api/sde", authChain.HandleFunc(app.getSde))
+	mux.Handle("POST /api/names", authChain.HandleFunc(app.postNames))
+
+	mux.Handle("GET /api/analytics", adminChain.HandleFunc(app.getAnalytics))
+
 	mux.Handle("GET /api/refresh/admin", adminChain.HandleFunc(app.refreshAdminToken))
 	mux.Handle("GET /api/config", workerChain.HandleFunc(app.getConfig))
 	mux.Handle("POST /api/config", workerChain.HandleFunc(app.postConfig))
 }
 
+const requisitionLockExpiry = time.Hour
+
 type GetBlueprintsBlueprint struct {
 	MaterialEfficiency int32 `json:"material_efficiency,omitempty"`
 	Quantity           int32 `json:"quantity,omitempty"`
+	Reserved           int32 `json:"reserved,omitempty"` // copies promised to open requisitions
+	Available          int32 `json:"available"`          // copies which can still be requested
 	Runs               int32 `json:"runs,omitempty"`
 	TimeEfficiency     int32 `json:"time_efficiency,omitempty"`
 	TypeId             int32 `json:"type_id,omitempty"`
+
+	Sources []blueprintSource `json:"sources,omitempty"` // owning corp and station of the copies
 }
 
 type GetBlueprintsType struct {
@@ -76,6 +109,28 @@ func (app *app) postConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// TODO: validate
+	if _, ok := allocationOrder[newConfig.AllocationStrategy]; !ok && newConfig.AllocationStrategy != "" {
+		httpError(w, "invalid allocation_strategy", http.StatusBadRequest)
+		return
+	}
+	for _, division := range newConfig.SourceDivisions {
+		if division < 1 || division > corpDivisions {
+			httpError(w, "invalid source_divisions, must be between 1 and "+strconv.Itoa(corpDivisions), http.StatusBadRequest)
+			return
+		}
+	}
+	if slices.Contains(newConfig.SourceStructures, 0) || slices.Contains(newConfig.SourceContainers, 0) {
+		httpError(w, "invalid source location id", http.StatusBadRequest)
+		return
+	}
+	seen := map[int32]bool{}
+	for _, corp := range newConfig.SourceCorps {
+		if corp.CorporationId == 0 || corp.CharacterId == 0 || seen[corp.CorporationId] {
+			httpError(w, "invalid source_corps, each needs a unique corporation_id and a character_id", http.StatusBadRequest)
+			return
+		}
+		seen[corp.CorporationId] = true
+	}
 
 	if err = app.dao.updateConfig(newConfig); err != nil {
 		logger.Error("error writing config to db", zap.Error(err))
@@ -87,7 +142,16 @@ func (app *app) postConfig(w http.ResponseWriter, r *http.Request) {
 		zap.String("updated_by", user.CharacterName),
 		zap.Any("old_config", app.config),
 		zap.Any("new_config", newConfig))
+	sourcesChanged := !slices.Equal(app.config.sourceCorps(), newConfig.sourceCorps())
 	app.config = newConfig
+
+	if sourcesChanged {
+		// poll with the new set of director tokens
+		select {
+		case app.adminTokenRefreshChan <- struct{}{}:
+		default:
+		}
+	}
 }
 
 func apiInvalid(w http.ResponseWriter, r *http.Request) {
@@ -95,8 +159,19 @@ func apiInvalid(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *app) getBlueprints(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	open, err := app.dao.listRequisitionOrders(0, requisitionStatus_Open)
+	if err != nil {
+		logger.Error("error fetching open requisitions", zap.Error(err))
+		httpError(w, "error fetching reservations", http.StatusInternalServerError)
+		return
+	}
+	reserved := newReservations(open)
+
 	app.invStateLock.RLock()
 	defer app.invStateLock.RUnlock()
+	app.writeInventoryAge(w)
 
 	resp := make([]GetBlueprintsType, len(app.inventoryState.bpcs))
 
@@ -105,13 +180,23 @@ func (app *app) getBlueprints(w http.ResponseWriter, r *http.Request) {
 		resp[i].TypeName = app.inventoryState.typeNames[typeId]
 		resp[i].Blueprints = make([]GetBlueprintsBlueprint, len(bpcs))
 
+		var typeStock int32
+		for _, bpc := range bpcs {
+			typeStock += bpc.Quantity
+		}
+		typeAvailable := typeStock - reserved.types[typeId]
+
 		for j, bpc := range bpcs {
+			quality := qualityOfBlueprint(bpc)
 			resp[i].Blueprints[j] = GetBlueprintsBlueprint{
 				MaterialEfficiency: bpc.MaterialEfficiency,
 				Quantity:           bpc.Quantity,
+				Reserved:           reserved.quality[quality],
+				Available:          max(min(bpc.Quantity-reserved.quality[quality], typeAvailable), 0),
 				Runs:               bpc.Runs,
 				TimeEfficiency:     bpc.TimeEfficiency,
 				TypeId:             bpc.TypeId,
+				Sources:            app.inventoryState.bpcSources[quality],
 			}
 		}
 
@@ -121,275 +206,377 @@ func (app *app) getBlueprints(w http.ResponseWriter, r *http.Request) {
 	httpWrite(w, resp)
 }
 
-func (app *app) setRequisitionLock(user *user, reqId int64) error {
-	lock, ok := app.getRequisitionLock(reqId)
-	if ok {
-		return fmt.Errorf("locked by %s at %s", lock.CharacterName, lock.LockedAt.Format(time.DateTime))
+func (app *app) patchRequisitionOrder(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+
+	action := r.PathValue("action")
+	if strings.Contains(r.Pattern, "cancel") {
+		action = "cancel"
 	}
 
-	app.requisitionLocks.Set(reqId, requisitionLock{
-		CharacterId:   user.CharacterId,
-		CharacterName: user.CharacterName,
-		LockedAt:      time.Now(),
-	})
-	return nil
-}
+	logger = logger.With(zap.Int64("id", reqId), zap.String("action", action))
+	logger.Debug("patchRequisitionOrder")
 
-func (app *app) deleteRequisitionLock(user *user, reqId int64) error {
-	lock, ok := app.getRequisitionLock(reqId)
-	if !ok {
-		return errors.New("requisition lock does not exist")
+	var body completeRequisitionRequest
+	switch action {
+	case "complete", "reject":
+		if err = readJsonBody(r, &body); err != nil {
+			logger.Debug("error reading request body", zap.Error(err))
+			httpError(w, "malformed request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if lock.CharacterId == user.CharacterId {
-		app.requisitionLocks.Delete(reqId)
+	if aErr := app.applyRequisitionAction(logger, user, reqId, action, body); aErr != nil {
+		aErr.write(w)
 	}
+}
 
-	return nil
+// requisitionUpdateError reports a failed requisition change, see requisitionUpdateFailure
+func (app *app) requisitionUpdateError(w http.ResponseWriter, logger *zap.Logger, err error, msg string) {
+	requisitionUpdateFailure(logger, err, msg).write(w)
 }
 
-func (app *app) getRequisitionLock(reqId int64) (*requisitionLock, bool) {
-	req, ok := app.requisitionLocks.Get(reqId)
-	lock := &req
-	if !ok {
-		return nil, false
-	}
+func (app *app) listRequisitionLocks(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+	logger.Debug("list requisition locks")
 
-	if time.Now().After(lock.LockedAt.Add(time.Hour)) {
-		app.requisitionLocks.Delete(reqId)
-		return nil, false
+	locks, err := app.dao.listRequisitionLocks()
+	if err != nil {
+		logger.Error("error listing requisition locks", zap.Error(err))
+		httpError(w, "error listing requisition locks", http.StatusInternalServerError)
+		return
 	}
 
-	return lock, true
+	httpWrite(w, slices.SortedFunc(maps.Values(locks), func(a, b requisitionLock) int {
+		return a.LockedAt.Compare(b.LockedAt)
+	}))
 }
 
-func (app *app) patchRequisitionOrder(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
+// forcibly remove a lock held by any character
+func (app *app) deleteRequisitionLock(w http.ResponseWriter, r *http.Request) {
 	user := app.getUserFromSession(r)
-	logger := getLoggerFromContext(r.Context()).Named("api")
-
 	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
 		httpError(w, "invalid requisition", http.StatusBadRequest)
 		return
 	}
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
 
-	action := r.PathValue("action")
-	if strings.Contains(r.Pattern, "cancel") {
-		action = "cancel"
+	ok, err := app.dao.releaseRequisitionLock(reqId, user, true)
+	if err != nil {
+		logger.Error("error releasing requisition lock", zap.Error(err))
+		httpError(w, "error releasing requisition lock", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "resource not locked", http.StatusNotFound)
+		return
 	}
 
-	logger = logger.With(zap.Int64("id", reqId), zap.String("action", action))
-	logger.Debug("patchRequisitionOrder")
+	logger.Warn("requisition lock force released", zap.String("released_by", user.CharacterName))
+	httpWrite(w, struct{}{})
+}
 
-	var notes string
+// list contracts matched against requisitions, optionally filtered with ?match=completed|mismatch
+func (app *app) listRequisitionContracts(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+	logger.Debug("list requisition contracts")
 
-	switch action {
-	case "lock":
-		if _, ok := app.getRequisitionLock(reqId); ok {
-			logger.Debug("attempting to lock pre-locked requisition")
-			httpError(w, "resource locked", http.StatusBadRequest)
-			return
-		}
+	match := r.URL.Query().Get("match")
+	switch match {
+	case "", contractMatch_Completed, contractMatch_Mismatch:
+	default:
+		httpError(w, "invalid match", http.StatusBadRequest)
+		return
+	}
 
-		req, err := app.dao.getRequisition(reqId)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				httpError(w, "invalid requisition", http.StatusBadRequest)
-				return
-			}
-			httpError(w, "error getting requisition", http.StatusInternalServerError)
-			return
-		}
+	contracts, err := app.dao.listRequisitionContracts(match)
+	if err != nil {
+		logger.Error("error listing requisition contracts", zap.Error(err))
+		httpError(w, "error listing requisition contracts", http.StatusInternalServerError)
+		return
+	}
 
-		if req.Status != requisitionStatus_Open {
-			httpError(w, "requisition is not open status="+req.Status.String(), http.StatusConflict)
-			return
-		}
+	httpWrite(w, contracts)
+}
 
-		app.setRequisitionLock(user, reqId)
+func (app *app) getRequisitionOrder(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+	logger.Debug("get requisition order")
 
-	case "unlock":
-		lock, ok := app.getRequisitionLock(reqId)
-		if !ok {
-			logger.Debug("attempting to unlock requisition that is not locked")
-			httpError(w, "resource not locked", http.StatusBadRequest)
-			return
-		}
-		if lock.CharacterId != user.CharacterId {
-			logger.Debug("attempting to unlock requisition locked by another user", zap.Any("lock", lock))
-			httpError(w, "can't unlock requisition, locked by "+lock.CharacterName, http.StatusForbidden)
-			return
-		}
+	var req *requisitionOrder
+	if req, err = app.dao.getRequisition(reqId); err != nil {
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
 
-		app.requisitionLocks.Delete(reqId)
+	if req.Lock, err = app.dao.getRequisitionLock(reqId); err != nil {
+		logger.Error("error getting requisition lock", zap.Error(err))
+		httpError(w, "error getting requisition lock", http.StatusInternalServerError)
+		return
+	}
 
-	case "cancel":
-		lock, ok := app.getRequisitionLock(reqId)
-		if ok {
-			logger.Debug("attempting to cancel requisition that is locked", zap.Any("lock", lock))
-			httpError(w, "resource is locked", http.StatusConflict)
-			return
-		}
+	req.redactFor(app.getUserFromSession(r))
+	httpWrite(w, req)
+}
 
-		req, err := app.dao.getRequisition(reqId)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				httpError(w, "invalid requisition", http.StatusBadRequest)
-				return
-			}
-			httpError(w, "error getting requisition", http.StatusInternalServerError)
-			return
-		}
+// list every recorded action taken on a requisition
+func (app *app) getRequisitionHistory(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+	logger.Debug("get requisition history")
 
-		if user.CharacterId != req.CharacterId {
-			httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "invalid requisition", http.StatusNotFound)
 			return
 		}
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
 
-		if req.Status != requisitionStatus_Open {
-			httpError(w, "requisition is not open status="+req.Status.String(), http.StatusConflict)
-			return
-		}
+	if !req.visibleTo(user) {
+		httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+		return
+	}
 
-		app.setRequisitionLock(user, reqId)
-		if err = app.dao.cancelRequisition(reqId, user.CharacterName); err != nil {
-			logger.Error("error cancelling requisition", zap.Error(err))
-			httpError(w, "error cancelling requisition", http.StatusInternalServerError)
-		}
-		app.deleteRequisitionLock(user, reqId)
+	events, err := app.dao.listRequisitionEvents(reqId)
+	if err != nil {
+		logger.Error("error listing requisition events", zap.Error(err))
+		httpError(w, "error getting requisition history", http.StatusInternalServerError)
+		return
+	}
 
-	case "complete":
-		lock, ok := app.getRequisitionLock(reqId)
-		if !ok {
-			logger.Debug("attempting to complete requisition that is not locked", zap.Any("lock", lock))
-			httpError(w, "resource not locked", http.StatusConflict)
-			return
-		}
-		if lock.CharacterId != user.CharacterId {
-			logger.Debug("can't complete requisition, locked by another user", zap.Any("lock", lock))
-			httpError(w, "can't complete requisition, locked by "+lock.CharacterName, http.StatusForbidden)
-			return
+	if user.Level < authLevel_Worker {
+		for i := range events {
+			events[i].PrivateNotes = ""
 		}
+	}
 
-		req, err := app.dao.getRequisition(reqId)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				httpError(w, "invalid requisition", http.StatusBadRequest)
-				return
-			}
-			httpError(w, "error getting requisition", http.StatusInternalServerError)
-			return
-		}
+	httpWrite(w, events)
+}
 
-		if req.Status != requisitionStatus_Open {
-			httpError(w, "requisition is not open status="+req.Status.String(), http.StatusConflict)
-			return
-		}
+func (app *app) getRequisitionComments(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+	logger.Debug("get requisition comments")
 
-		if err := app.dao.completeRequisition(reqId, user.CharacterName, notes); err != nil {
-			logger.Error("error completing requisition", zap.Error(err))
-			httpError(w, "error completing requisition", http.StatusInternalServerError)
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "invalid requisition", http.StatusNotFound)
 			return
 		}
-		app.deleteRequisitionLock(user, reqId)
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
 
-	case "reject":
-		lock, ok := app.getRequisitionLock(reqId)
-		if !ok {
-			logger.Debug("attempting to complete requisition that is not locked")
-			httpError(w, "resource not locked", http.StatusConflict)
-			return
-		}
-		if lock.CharacterId != user.CharacterId {
-			logger.Debug("can't reject requisition, locked by another user", zap.Any("lock", lock))
-			httpError(w, "can't reject requisition, locked by "+lock.CharacterName, http.StatusForbidden)
-			return
-		}
+	if !req.visibleTo(user) {
+		httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+		return
+	}
 
-		req, err := app.dao.getRequisition(reqId)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				httpError(w, "invalid requisition", http.StatusBadRequest)
-				return
-			}
-			httpError(w, "error getting requisition", http.StatusInternalServerError)
-			return
-		}
+	comments, err := app.dao.listRequisitionComments(reqId)
+	if err != nil {
+		logger.Error("error listing requisition comments", zap.Error(err))
+		httpError(w, "error getting comments", http.StatusInternalServerError)
+		return
+	}
 
-		if req.Status != requisitionStatus_Open {
-			httpError(w, "requisition is not open status="+req.Status.String(), http.StatusConflict)
-			return
-		}
+	httpWrite(w, comments)
+}
 
-		if err := app.dao.rejectRequisition(reqId, user.CharacterName, notes); err != nil {
-			logger.Error("error rejecting requisition", zap.Error(err))
-			httpError(w, "error rejecting requisition", http.StatusInternalServerError)
-			return
-		}
-		app.deleteRequisitionLock(user, reqId)
-	}
+type postRequisitionCommentRequest struct {
+	Body     string `json:"body,omitempty"`
+	ParentId int64  `json:"parent_id,omitempty"`
 }
 
-func (app *app) getRequisitionOrder(w http.ResponseWriter, r *http.Request) {
+const maxCommentLength = 2000
+
+// add a comment to a requisition, or reply to an existing comment when parent_id is set
+func (app *app) postRequisitionComment(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
 		httpError(w, "invalid requisition", http.StatusBadRequest)
 		return
 	}
+	user := app.getUserFromSession(r)
 	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
-	logger.Debug("get requisition order")
+	logger.Debug("post requisition comment")
 
-	var req *requisitionOrder
-	if req, err = app.dao.getRequisition(reqId); err != nil {
+	var body postRequisitionCommentRequest
+	if err = readJsonBody(r, &body); err != nil {
+		httpError(w, "malformed comment", http.StatusBadRequest)
+		return
+	}
+
+	body.Body = strings.TrimSpace(body.Body)
+	if len(body.Body) == 0 || len(body.Body) > maxCommentLength {
+		httpError(w, "comment must be between 1 and "+strconv.Itoa(maxCommentLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "invalid requisition", http.StatusNotFound)
+			return
+		}
 		logger.Error("error getting requisition", zap.Error(err))
 		httpError(w, "error getting requisition", http.StatusInternalServerError)
 		return
 	}
 
-	lock, _ := app.getRequisitionLock(reqId)
-	req.Lock = lock
+	if !req.visibleTo(user) {
+		httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+		return
+	}
 
-	httpWrite(w, req)
+	comment := &requisitionComment{
+		RequisitionId: reqId,
+		ParentId:      body.ParentId,
+		CharacterId:   user.CharacterId,
+		CharacterName: user.CharacterName,
+		Body:          body.Body,
+	}
+	if err = app.dao.createRequisitionComment(comment); err != nil {
+		if errors.Is(err, errInvalidParentComment) {
+			httpError(w, "invalid parent comment", http.StatusBadRequest)
+			return
+		}
+		logger.Error("error creating comment", zap.Error(err))
+		httpError(w, "error creating comment", http.StatusInternalServerError)
+		return
+	}
+
+	httpWrite(w, comment)
 }
 
+// list a page of requisitions, see parseRequisitionFilter for the query parameters.
+// The cursor for the next page is returned in the X-Next-Cursor header.
 func (app *app) listRequisitionOrders(w http.ResponseWriter, r *http.Request) {
 	logger := getLoggerFromContext(r.Context()).Named("api")
 	logger.Debug("list requisition orders")
 
-	status := requisitionStatus_Open
-	strStatus := r.URL.Query().Get("status")
-	intStatus, err := strconv.ParseInt(strStatus, 10, 64)
-	if err == nil {
-		status = requisitionStatus(intStatus)
+	filter, err := parseRequisitionFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	user := app.getUserFromSession(r)
-	characterId := user.CharacterId
-
-	if user.Level >= authLevel_Worker {
-		parsedChar, _ := strconv.ParseInt(r.URL.Query().Get("character_id"), 10, 64)
-		characterId = int32(parsedChar)
+	if user.Level < authLevel_Worker {
+		filter.CharacterId = user.CharacterId
 	}
 
-	orders, err := app.dao.listRequisitionOrders(characterId, status)
+	orders, next, err := app.dao.queryRequisitionOrders(filter)
 	if err != nil {
 		logger.Error("error fetching requisition orders", zap.Error(err))
 		httpError(w, "error fetching requisition orders", http.StatusInternalServerError)
 		return
 	}
 
+	locks, err := app.dao.listRequisitionLocks()
+	if err != nil {
+		logger.Error("error fetching requisition locks", zap.Error(err))
+		httpError(w, "error fetching requisition locks", http.StatusInternalServerError)
+		return
+	}
+
 	for i := range orders {
-		lock, _ := app.getRequisitionLock(orders[i].Id)
-		orders[i].Lock = lock
+		if lock, ok := locks[orders[i].Id]; ok {
+			orders[i].Lock = &lock
+		}
+		orders[i].redactFor(user)
 	}
 
+	if next != "" {
+		w.Header().Set(headerNextCursor, next)
+	}
 	httpWrite(w, orders)
 }
 
 // Create a new requisition order
 // expects a postRequisitionOrderRequest in the body
 func (app *app) postRequisitionOrder(w http.ResponseWriter, r *http.Request) {
+	app.saveRequisitionOrder(w, r, 0)
+}
+
+// Replace the blueprints of an open requisition, keeping its place in the queue
+// expects a postRequisitionOrderRequest in the body
+func (app *app) putRequisitionOrder(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "invalid requisition", http.StatusNotFound)
+			return
+		}
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
+
+	if user.CharacterId != req.CharacterId {
+		httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+		return
+	}
+
+	if req.Status != requisitionStatus_Open {
+		httpError(w, "requisition is not open status="+req.Status.String(), http.StatusConflict)
+		return
+	}
+
+	if lock, err := app.dao.getRequisitionLock(reqId); err != nil {
+		logger.Error("error getting requisition lock", zap.Error(err))
+		httpError(w, "error getting requisition lock", http.StatusInternalServerError)
+		return
+	} else if lock != nil {
+		httpError(w, "requisition is being processed by "+lock.CharacterName, http.StatusConflict)
+		return
+	}
+
+	app.saveRequisitionOrder(w, r, reqId)
+}
+
+// saveRequisitionOrder creates a requisition, or edits reqId if it's non-zero
+func (app *app) saveRequisitionOrder(w http.ResponseWriter, r *http.Request, reqId int64) {
 	var (
 		user   = app.getUserFromSession(r)
 		logger = getLoggerFromContext(r.Context()).Named("api")
@@ -415,11 +602,72 @@ func (app *app) postRequisitionOrder(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "invalid request", http.StatusBadRequest)
 		return
 	}
+	for i := range bpReq.Blueprints {
+		if bpReq.Blueprints[i].Quantity < 1 {
+			httpError(w, "invalid quantity, each blueprint needs at least 1 copy", http.StatusBadRequest)
+			return
+		}
+		bpReq.Blueprints[i].Fulfillment = nil // only set by fulfill when the requisition is completed
+	}
+
+	app.invStateLock.RLock()
+	defer app.invStateLock.RUnlock()
+	if app.inventoryState.updatedAt.IsZero() {
+		httpError(w, "blueprint inventory not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	check := app.policyCheck(user, bpReq.Blueprints, reqId)
+	if reqId == 0 {
+		reqId, err = app.dao.createRequisition(user, bpReq.Blueprints, check)
+	} else {
+		logger = logger.With(zap.Int64("id", reqId))
+		_, err = app.dao.editRequisition(reqId, user, bpReq.Blueprints, check)
+	}
+	if err != nil {
+		app.requisitionUpdateError(w, logger, err, "error saving requisition")
+		return
+	}
+
+	logger.Debug("saved requisition order", zap.Int64("id", reqId))
+	httpWrite(w, struct {
+		Id int64 `json:"id"`
+	}{reqId})
+}
+
+// list every version of the blueprints requested
+func (app *app) getRequisitionVersions(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+	logger.Debug("get requisition versions")
 
-	if err := app.dao.createRequisition(user.CharacterId, user.CharacterName, bpReq.Blueprints); err != nil {
-		httpError(w, "error creating requisition", http.StatusInternalServerError)
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "invalid requisition", http.StatusNotFound)
+			return
+		}
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
+
+	if !req.visibleTo(user) {
+		httpError(w, "user/owner mismatch", http.StatusUnauthorized)
+		return
+	}
+
+	versions, err := app.dao.listRequisitionVersions(reqId)
+	if err != nil {
+		logger.Error("error listing requisition versions", zap.Error(err))
+		httpError(w, "error getting requisition versions", http.StatusInternalServerError)
 		return
 	}
 
-	logger.Debug("created requisition order")
+	httpWrite(w, versions)
 }
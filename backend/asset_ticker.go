@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"errors"
-	"io"
+	"fmt"
+	"maps"
 	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"slices"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,20 +20,18 @@ import (
 	"go.uber.org/zap"
 )
 
-const (
-	scienceLounge int64 = 1047935338899
-	bpHangar            = string(glue.LocationFlag_CorpSAG7)
-)
+// inventoryFullRefreshInterval is how often every name is fetched again, in between only unknown ids are looked up
+const inventoryFullRefreshInterval = 24 * time.Hour
 
 var (
-	errCtxInitial      = errors.New("initial oauth context")
-	errCtxCreateFailed = errors.New("createOauthContext failed")
+	errCtxCreateFailed    = errors.New("createOauthContext failed")
+	errInventoryUnchanged = errors.New("inventory unchanged")
 )
 
-func (app *app) createOauthContext(logger *zap.Logger) context.Context {
-	pair := app.getAdminToken(logger)
+func (app *app) createOauthContext(logger *zap.Logger, corp sourceCorp) context.Context {
+	pair := app.getCorpToken(logger, corp.CharacterId)
 	if len(pair.scope) == 0 {
-		logger.Error("no available tokens for admin character", zap.Int32("character_id", app.config.AdminCharacter))
+		logger.Error("no available tokens for director character", zap.Int32("corporation_id", corp.CorporationId), zap.Int32("character_id", corp.CharacterId))
 		ctx, cancel := context.WithCancelCause(context.Background())
 		cancel(errCtxCreateFailed)
 		return ctx
@@ -40,31 +40,49 @@ func (app *app) createOauthContext(logger *zap.Logger) context.Context {
 	return context.WithValue(context.Background(), goesi.ContextOAuth2, pair.token)
 }
 
+// corpContexts are the authenticated esi contexts of each source corp, keyed by corporation id
+type corpContexts map[int32]context.Context
+
+// valid returns the contexts which can still be used
+func (c corpContexts) valid() corpContexts {
+	out := corpContexts{}
+	for corporationId, ctx := range c {
+		if ctx.Err() == nil {
+			out[corporationId] = ctx
+		}
+	}
+	return out
+}
+
 func (app *app) ticker(ctx context.Context) {
 	var (
 		logger        = app.logger.Named("ticker")
 		ticker        = time.NewTicker(time.Second)
 		refreshTicker = time.NewTicker(time.Minute)
-		esiCtx        context.Context
+		esiCtxs       = corpContexts{}
 	)
 	app.adminTokenRefreshChan <- struct{}{} // manually trigger a token refresh
 	ticker.Stop()                           // stop the ticker until we get a valid esiCtx
 
-	{
-		var cancel context.CancelCauseFunc
-		esiCtx, cancel = context.WithCancelCause(context.Background())
-		cancel(errCtxInitial)
-	}
-
-	refreshToken := func(ctx context.Context) context.Context {
-		logger.Debug("refreshing token", zap.NamedError("cause", context.Cause(ctx)))
-		ctx = app.createOauthContext(logger)
-		if ctx.Err() == nil {
+	refreshTokens := func() corpContexts {
+		logger.Debug("refreshing tokens")
+		ctxs := corpContexts{}
+		for _, corp := range app.config.sourceCorps() {
+			ctxs[corp.CorporationId] = app.createOauthContext(logger, corp)
+		}
+		valid := ctxs.valid()
+		if len(valid) < len(ctxs) {
+			// keep retrying corps without a token, their director may log in at any time
+			refreshTicker.Reset(time.Minute)
+		} else {
 			refreshTicker.Stop()
+		}
+		if len(valid) > len(esiCtxs.valid()) {
+			// poll straight away when a corp becomes available
 			ticker.Reset(time.Second)
 		}
 
-		return ctx
+		return ctxs
 	}
 
 	for {
@@ -74,100 +92,232 @@ func (app *app) ticker(ctx context.Context) {
 			return
 
 		case <-refreshTicker.C:
-			esiCtx = refreshToken(esiCtx)
+			esiCtxs = refreshTokens()
 
 		case <-app.adminTokenRefreshChan:
-			esiCtx = refreshToken(esiCtx)
+			esiCtxs = refreshTokens()
 
 		case <-ticker.C:
-			if esiCtx.Err() != nil {
+			valid := esiCtxs.valid()
+			if len(valid) == 0 {
 				ticker.Stop()
 				refreshTicker.Reset(time.Minute)
 				app.adminTokenRefreshChan <- struct{}{}
 				break
 			}
-
-			var jitter time.Duration
-			switch app.runtimeConfig.environment {
-			case "prod", "production":
-				jitter = time.Duration(rand.Int64N(int64(2 * time.Second)))
+			if len(valid) < len(esiCtxs) {
+				// corps without a token are left out of the inventory until refreshTicker finds one for them
+				logger.Warn("polling a subset of source corps", zap.Int("valid", len(valid)), zap.Int("configured", len(esiCtxs)))
 			}
-			ticker.Reset(time.Hour + jitter)
 
-			invState, err := app.updateBlueprintInventory(esiCtx, logger, false)
-			if err != nil {
+			tickStart := time.Now()
+			ticker.Reset(maxRefreshInterval) // in case the update takes longer than the shortest expiry
+
+			app.invStateLock.RLock()
+			incremental := time.Since(app.inventoryState.fullRefreshAt) < inventoryFullRefreshInterval
+			app.invStateLock.RUnlock()
+
+			invState, err := app.updateBlueprintInventory(valid, logger, incremental)
+			if errors.Is(err, errInventoryUnchanged) {
+				logger.Debug("blueprint inventory unchanged")
+			} else if err != nil {
 				logger.Error(err.Error())
 			} else {
 				app.invStateLock.Lock()
+				prev := app.inventoryState
 				app.inventoryState = invState
 				app.invStateLock.Unlock()
+
+				if !prev.updatedAt.IsZero() {
+					changes := diffInventory(prev, invState)
+					logger.Debug("inventory changes", zap.Int("changes", len(changes)))
+					if err = app.dao.insertInventoryChanges(changes); err != nil {
+						logger.Error("error recording inventory changes", zap.Error(err))
+					}
+				}
+
+				if err = app.dao.saveInventorySnapshot(invState.snapshot()); err != nil {
+					logger.Error("error saving inventory snapshot", zap.Error(err))
+				}
+
+				if err = app.checkStockTargets(ctx, logger); err != nil {
+					logger.Error("error checking stock targets", zap.Error(err))
+				}
+			}
+
+			for corporationId, esiCtx := range valid {
+				corpLogger := logger.With(zap.Int32("corporation_id", corporationId))
+				if err = app.syncCorpIndustryJobs(esiCtx, corpLogger, corporationId); err != nil {
+					corpLogger.Error("error syncing corp industry jobs", zap.Error(err))
+				}
+
+				if err = app.syncCorpContracts(esiCtx, corpLogger, app.dao, corporationId); err != nil {
+					corpLogger.Error("error syncing corp contracts", zap.Error(err))
+				}
+			}
+
+			// poll again as soon as any of the responses used this tick expires
+			var jitter time.Duration
+			switch app.runtimeConfig.environment {
+			case "prod", "production":
+				jitter = time.Duration(rand.Int64N(int64(2 * time.Second)))
 			}
+			next := app.esiCache.nextExpiry(tickStart)
+			logger.Debug("next update", zap.Time("at", next))
+			ticker.Reset(time.Until(next) + jitter)
 		}
 	}
 }
 
 type inventoryState struct {
+	updatedAt      time.Time
+	fullRefreshAt  time.Time // when names were last fetched from scratch, see inventoryFullRefreshInterval
 	blueprints     []esi.GetCorporationsCorporationIdBlueprints200Ok
 	assets         []esi.GetCorporationsCorporationIdAssets200Ok
 	bpcs           map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok
+	bpcItems       map[blueprintQuality][]esi.GetCorporationsCorporationIdBlueprints200Ok // the individual copies in each bpcs stack
+	bpcSources     map[blueprintQuality][]blueprintSource                                 // where the copies in each bpcs stack are held
 	bpos           map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok
+	owners         map[int64]int32 // corporation id owning each asset and blueprint item
+	config         *appConfig      // the config the inventory was filtered with
+	corporations   []int32         // source corps included in the inventory
 	containerNames map[int64]string
-	hangarNames    []string
+	hangarNames    map[int32][]string // division names of each corp
 	typeNames      map[int32]string
 	tree           map[int64]*CorpAsset
 }
 
-func (app *app) updateBlueprintInventory(ctx context.Context, logger *zap.Logger, incremental bool) (*inventoryState, error) {
+// blueprintSource is the copies of a stack held by one corp at one station or structure
+type blueprintSource struct {
+	CorporationId int32 `json:"corporation_id"`
+	LocationId    int64 `json:"location_id"`
+	Quantity      int32 `json:"quantity"`
+}
+
+// corpInventory is the raw blueprints and assets of a single source corp
+type corpInventory struct {
+	blueprints []esi.GetCorporationsCorporationIdBlueprints200Ok
+	assets     []esi.GetCorporationsCorporationIdAssets200Ok
+}
+
+// updateBlueprintInventory fetches the blueprints of every source corp with a valid context and merges
+// them into a single catalogue.
+// An incremental update starts from the names of the current inventory and only looks up ids it hasn't seen,
+// reusing the asset tree if no asset page changed. Otherwise every name is fetched again.
+func (app *app) updateBlueprintInventory(ctxs corpContexts, logger *zap.Logger, incremental bool) (*inventoryState, error) {
 	var (
 		start              = time.Now()
-		unknownLocationIds []int64
+		unknownLocationIds = map[int32][]int64{}
 		unknownTypeIds     []int32
-		err                error
 		inv                = &inventoryState{
-			bpos: make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
-			bpcs: make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+			fullRefreshAt:  start,
+			bpos:           make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+			bpcs:           make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+			bpcItems:       make(map[blueprintQuality][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+			bpcSources:     make(map[blueprintQuality][]blueprintSource),
+			owners:         make(map[int64]int32),
+			containerNames: make(map[int64]string),
+			hangarNames:    make(map[int32][]string),
+			typeNames:      make(map[int32]string),
 		}
 	)
 
-	if inv.blueprints, err = app.fetchCorpBlueprints(ctx, logger); err != nil {
+	// the asset tree is needed to decide which blueprints are in a source location
+	var (
+		mu    sync.Mutex
+		errs  []error
+		corps = make(map[int32]corpInventory, len(ctxs))
+	)
+	wg := sync.WaitGroup{}
+	for corporationId, ctx := range ctxs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			corpLogger := logger.With(zap.Int32("corporation_id", corporationId))
+
+			var ci corpInventory
+			var assetErr, bpErr error
+			inner := sync.WaitGroup{}
+			inner.Add(1)
+			go func() {
+				defer inner.Done()
+				ci.assets, assetErr = app.fetchCorpAssets(ctx, corpLogger, corporationId)
+			}()
+			ci.blueprints, bpErr = app.fetchCorpBlueprints(ctx, corpLogger, corporationId)
+			inner.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := errors.Join(bpErr, assetErr); err != nil {
+				errs = append(errs, fmt.Errorf("corporation %d: %w", corporationId, err))
+				return
+			}
+			corps[corporationId] = ci
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
 		return nil, err
 	}
 
+	inv.config = app.config
+	inv.corporations = slices.Sorted(maps.Keys(corps))
+
+	// copy what's reused from the current inventory and release the lock before any esi calls, writers wait behind it
 	app.invStateLock.RLock()
-	defer app.invStateLock.RUnlock()
+	prev := *app.inventoryState
+	if incremental {
+		inv.fullRefreshAt = prev.fullRefreshAt
+		maps.Copy(inv.typeNames, prev.typeNames)
+		maps.Copy(inv.containerNames, prev.containerNames)
+		maps.Copy(inv.hangarNames, prev.hangarNames)
+	}
+	app.invStateLock.RUnlock()
+
+	// the esi cache knows if any page changed, rebuilding an identical inventory is wasted work.
+	// a full refresh goes ahead anyway to pick up renamed containers and divisions.
+	sameCorps := !prev.updatedAt.IsZero() && slices.Equal(prev.corporations, inv.corporations)
+	if incremental && sameCorps && prev.config == inv.config && !app.esiCache.modifiedSince(prev.updatedAt, isInventoryUrl) {
+		return nil, errInventoryUnchanged
+	}
+
+	for corporationId, ci := range corps {
+		for _, asset := range ci.assets {
+			inv.owners[asset.ItemId] = corporationId
+		}
+		for _, bp := range ci.blueprints {
+			inv.owners[bp.ItemId] = corporationId
+		}
+		inv.blueprints = append(inv.blueprints, ci.blueprints...)
+	}
+
+	// every asset page was revalidated with its etag, if none changed the previous tree is still good
+	if incremental && sameCorps && !app.esiCache.modifiedSince(prev.updatedAt, isAssetsUrl) {
+		logger.Debug("assets unchanged, reusing asset tree")
+		inv.assets = prev.assets
+		inv.tree = prev.tree
+	} else {
+		for _, ci := range corps {
+			inv.assets = append(inv.assets, ci.assets...)
+		}
+		inv.tree = app.buildAssetTree(inv.assets)
+	}
 
 	// populate bpo/bpc with a total count of each type/quality
 	for _, bp := range inv.blueprints {
-		// vv temp filtering code vv
-		if bp.LocationFlag != bpHangar {
+		if !inv.inSourceLocation(inv.config, bp.ItemId, bp.LocationId, bp.LocationFlag) {
 			continue
 		}
-		if parent, ok := inv.tree[bp.LocationId]; ok {
-			if parent.Asset.LocationId != scienceLounge {
-				if gp, gpok := inv.tree[parent.Asset.LocationId]; !gpok {
-					continue
-				} else {
-					if gp.Asset.LocationId != scienceLounge {
-						continue
-					}
-				}
-			}
-		}
-		// ^^ temp filtering code ^^
 
-		var (
-			m   map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok
-			qty int32 = 1
-		)
-
-		if _, ok := app.inventoryState.typeNames[bp.TypeId]; !ok || !incremental {
+		if _, ok := inv.typeNames[bp.TypeId]; !ok {
 			unknownTypeIds = append(unknownTypeIds, bp.TypeId)
 		}
 
-		if _, ok := app.inventoryState.tree[bp.LocationId]; !ok {
+		if _, ok := inv.containerNames[bp.LocationId]; !ok {
 			switch glue.LocationFlag(bp.LocationFlag) {
 			default:
-				unknownLocationIds = append(unknownLocationIds, bp.LocationId)
+				owner := inv.owners[bp.ItemId]
+				unknownLocationIds[owner] = append(unknownLocationIds[owner], bp.LocationId)
 			case // noop for these location flags as they will error when calling the universe/names endpoint
 				glue.LocationFlag_AssetSafety,
 				glue.LocationFlag_CorpDeliveries,
@@ -175,174 +325,136 @@ func (app *app) updateBlueprintInventory(ctx context.Context, logger *zap.Logger
 			}
 		}
 
-		switch bp.Quantity {
-		case -2: // BPC
-			m = inv.bpcs
-		case -1: // researched BPO
-			m = inv.bpos
-		default: // BPO stack > 0
-			m = inv.bpos
-			qty = bp.Quantity
-		}
-
-		idx := -1
-		if _, ok := m[bp.TypeId]; !ok {
-			m[bp.TypeId] = esi.GetCorporationsCorporationIdBlueprints200OkList{}
-		} else {
-			idx = slices.IndexFunc(m[bp.TypeId], func(e esi.GetCorporationsCorporationIdBlueprints200Ok) bool {
-				return sameBlueprintQuality(e, bp)
-			})
-		}
-
-		if idx == -1 { // equivalent blueprint not found
-			b := bp
-			b.Quantity = qty
-			m[bp.TypeId] = append(m[bp.TypeId], b)
-		} else {
-			m[bp.TypeId][idx].Quantity += qty
-		}
+		inv.catalogue(bp)
 	}
 
-	wg := sync.WaitGroup{}
-	if len(unknownLocationIds) > 0 || !incremental {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			inv.hangarNames = app.fetchCorpHangarNames(ctx, logger)
-		}()
+	for q, items := range inv.bpcItems {
+		inv.bpcSources[q] = inv.sourcesOf(items)
+	}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			inv.containerNames = app.fetchCorpItemNames(ctx, logger, unknownLocationIds)
-		}()
+	for corporationId, ctx := range ctxs {
+		if _, ok := inv.hangarNames[corporationId]; !ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				names := app.fetchCorpHangarNames(ctx, logger, corporationId)
+				mu.Lock()
+				inv.hangarNames[corporationId] = names
+				mu.Unlock()
+			}()
+		}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			inv.assets, _ = app.fetchCorpAssets(ctx, logger)
-		}()
+		if itemIds := unknownLocationIds[corporationId]; len(itemIds) > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				names := app.fetchCorpItemNames(ctx, logger, corporationId, itemIds)
+				mu.Lock()
+				defer mu.Unlock()
+				// locations without a name, such as hangars, aren't asked for again until the next full refresh
+				for _, id := range itemIds {
+					inv.containerNames[id] = names[id]
+				}
+			}()
+		}
 	}
 
-	if len(unknownTypeIds) > 0 || !incremental {
+	if len(unknownTypeIds) > 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			inv.typeNames = app.fetchTypeNames(ctx, logger, glue.NameCategory_InventoryType, unknownTypeIds)
+			// type names are public, no token is needed
+			names := app.resolveTypeNames(context.Background(), logger, unknownTypeIds)
+			mu.Lock()
+			maps.Copy(inv.typeNames, names)
+			mu.Unlock()
 		}()
 	}
 
 	wg.Wait()
 
-	inv.tree = app.buildAssetTree(inv.assets)
+	inv.updatedAt = time.Now()
 
-	logger.Debug("updated blueprint inventory", zap.Duration("duration", time.Since(start)))
+	logger.Debug("updated blueprint inventory", zap.Int("corporations", len(corps)), zap.Bool("incremental", incremental),
+		zap.Int("unknown_types", len(unknownTypeIds)), zap.Duration("duration", time.Since(start)))
 	fetchBlueprintDuration.Observe(time.Since(start).Seconds())
 	return inv, nil
 }
 
-func (app *app) fetchCorpAssets(ctx context.Context, logger *zap.Logger) ([]esi.GetCorporationsCorporationIdAssets200Ok, error) {
+// catalogue adds a blueprint in a source location to the bpo or bpc stack of the same quality
+func (inv *inventoryState) catalogue(bp esi.GetCorporationsCorporationIdBlueprints200Ok) {
 	var (
-		start         = time.Now()
-		pages   int64 = 1
-		attempt int   = 1
-		assets  []esi.GetCorporationsCorporationIdAssets200Ok
+		m   map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok
+		qty int32 = 1
 	)
 
-	for page := int32(1); page <= int32(pages); page++ {
-		reqCtx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-		defer cancel()
+	switch bp.Quantity {
+	case -2: // BPC
+		m = inv.bpcs
+		inv.bpcItems[qualityOfBlueprint(bp)] = append(inv.bpcItems[qualityOfBlueprint(bp)], bp)
+	case -1: // researched BPO
+		m = inv.bpos
+	default: // BPO stack > 0
+		m = inv.bpos
+		qty = bp.Quantity
+	}
+
+	idx := -1
+	if _, ok := m[bp.TypeId]; !ok {
+		m[bp.TypeId] = esi.GetCorporationsCorporationIdBlueprints200OkList{}
+	} else {
+		idx = slices.IndexFunc(m[bp.TypeId], func(e esi.GetCorporationsCorporationIdBlueprints200Ok) bool {
+			return sameBlueprintQuality(e, bp)
+		})
+	}
+
+	if idx == -1 { // equivalent blueprint not found
+		b := bp
+		b.Quantity = qty
+		m[bp.TypeId] = append(m[bp.TypeId], b)
+	} else {
+		m[bp.TypeId][idx].Quantity += qty
+	}
+}
+
+// isInventoryUrl matches the esi endpoints the blueprint inventory is built from
+func isInventoryUrl(u *url.URL) bool {
+	return isAssetsUrl(u) || strings.Contains(u.Path, "/corporations/") && strings.HasSuffix(u.Path, "/blueprints/")
+}
+
+func isAssetsUrl(u *url.URL) bool {
+	return strings.Contains(u.Path, "/corporations/") && strings.HasSuffix(u.Path, "/assets/")
+}
 
-		ap, resp, err := app.esi.ESI.AssetsApi.GetCorporationsCorporationIdAssets(reqCtx, app.config.AdminCorp,
+func (app *app) fetchCorpAssets(ctx context.Context, logger *zap.Logger, corporationId int32) ([]esi.GetCorporationsCorporationIdAssets200Ok, error) {
+	start := time.Now()
+	assets, err := esiPages(ctx, logger, func(ctx context.Context, page int32) ([]esi.GetCorporationsCorporationIdAssets200Ok, *http.Response, error) {
+		return app.esi.ESI.AssetsApi.GetCorporationsCorporationIdAssets(ctx, corporationId,
 			&esi.GetCorporationsCorporationIdAssetsOpts{
 				Page: optional.NewInt32(page),
 			})
-		if err != nil {
-			body := parseEsiError(err)
-			logger.Error("error fetching assets", zap.Int32("page", page), zap.String("body", body), zap.Error(err))
-			if attempt > 5 {
-				return nil, errors.New("retries exceeded")
-			}
-			page--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			// some other error happened. print to logs
-			var buf []byte
-			resp.Body.Read(buf)
-			logger.Warn("status not 200", zap.Int32("page", page), zap.String("status_code", resp.Status), zap.String("body", string(buf)))
-			if attempt > 5 {
-				return nil, errors.New("retries exceeded")
-			}
-			page--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			resp.Body.Close()
-			continue
-		}
-
-		assets = append(assets, ap...)
-		pages, _ = strconv.ParseInt(resp.Header.Get(headerPages), 10, 64)
-		attempt = 1
-		resp.Body.Close()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching assets: %w", err)
 	}
 
-	logger.Info("fetched assets", zap.Int64("pages", pages), zap.Int("assets", len(assets)), zap.Duration("duration", time.Since(start)))
+	logger.Info("fetched assets", zap.Int("assets", len(assets)), zap.Duration("duration", time.Since(start)))
 	return assets, nil
 }
 
-func (app *app) fetchCorpBlueprints(ctx context.Context, logger *zap.Logger) ([]esi.GetCorporationsCorporationIdBlueprints200Ok, error) {
-	var (
-		start            = time.Now()
-		pages      int64 = 1
-		attempt          = 1
-		blueprints []esi.GetCorporationsCorporationIdBlueprints200Ok
-	)
-
-	for page := int32(1); page <= int32(pages); page++ {
-		reqCtx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-		defer cancel()
-
-		bp, resp, err := app.esi.ESI.CorporationApi.GetCorporationsCorporationIdBlueprints(reqCtx, app.config.AdminCorp,
+func (app *app) fetchCorpBlueprints(ctx context.Context, logger *zap.Logger, corporationId int32) ([]esi.GetCorporationsCorporationIdBlueprints200Ok, error) {
+	start := time.Now()
+	blueprints, err := esiPages(ctx, logger, func(ctx context.Context, page int32) ([]esi.GetCorporationsCorporationIdBlueprints200Ok, *http.Response, error) {
+		return app.esi.ESI.CorporationApi.GetCorporationsCorporationIdBlueprints(ctx, corporationId,
 			&esi.GetCorporationsCorporationIdBlueprintsOpts{
 				Page: optional.NewInt32(page),
 			})
-
-		if err != nil {
-			body := parseEsiError(err)
-			logger.Warn("error fetching blueprints", zap.Int("attempt", attempt), zap.Int32("page", page), zap.String("body", body), zap.Error(err))
-			if attempt > 5 {
-				return nil, errors.New("retries exceeded")
-			}
-
-			page--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			// some other error happened. print to logs
-			var buf []byte
-			resp.Body.Read(buf)
-			logger.Warn("status not 200", zap.Int32("page", page), zap.String("status_code", resp.Status), zap.String("body", string(buf)))
-			if attempt > 5 {
-				return nil, errors.New("retries exceeded")
-			}
-
-			page--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			resp.Body.Close()
-			continue
-		}
-
-		blueprints = append(blueprints, bp...)
-		pages, _ = strconv.ParseInt(resp.Header.Get(headerPages), 10, 64)
-		resp.Body.Close()
-		attempt = 1
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching blueprints: %w", err)
 	}
 
-	logger.Info("finished fetching blueprints", zap.Int64("pages", pages), zap.Duration("duration", time.Since(start)))
+	logger.Info("finished fetching blueprints", zap.Int("blueprints", len(blueprints)), zap.Duration("duration", time.Since(start)))
 	return blueprints, nil
 }
 
@@ -355,20 +467,12 @@ func (app *app) fetchTypeNames(ctx context.Context, logger *zap.Logger, category
 		return names
 	}
 
-	chunks := slices.Chunk(typeIds, 1000)
-	for chunk := range chunks {
-		cctx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-		defer cancel()
-
-		namePage, resp, err := app.esi.ESI.UniverseApi.PostUniverseNames(cctx, chunk, nil)
+	for chunk := range slices.Chunk(typeIds, 1000) {
+		namePage, _, err := esiCall(ctx, logger, func(ctx context.Context) ([]esi.PostUniverseNames200Ok, *http.Response, error) {
+			return app.esi.ESI.UniverseApi.PostUniverseNames(ctx, chunk, nil)
+		})
 		if err != nil {
-			body := parseEsiError(err)
-			logger.Error("error fetching type names", zap.String("body", body), zap.Error(err))
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			logger.Error("error fetching type names", zap.String("status", resp.Status), zap.Error(err), zap.String("body", string(body)))
-			resp.Body.Close()
+			logger.Error("error fetching type names", zap.Error(err))
 			continue
 		}
 
@@ -377,8 +481,6 @@ func (app *app) fetchTypeNames(ctx context.Context, logger *zap.Logger, category
 				names[v.Id] = v.Name
 			}
 		}
-
-		resp.Body.Close()
 	}
 
 	return names
@@ -393,72 +495,47 @@ func (app *app) fetchStructures(ctx context.Context, logger *zap.Logger, structu
 		return structures
 	}
 
-	attempt := 1
-	for i, structureId := range structureIds {
+	for _, structureId := range structureIds {
 		var (
 			structureData esi.GetUniverseStructuresStructureIdOk
-			stationData   esi.GetUniverseStationsStationIdOk
-			resp          *http.Response
 			err           error
 		)
-		cctx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-		defer cancel()
 
 		structureType := glue.ResolveLoctionType(structureId)
 		switch structureType {
 		case glue.LocationType_Station:
-			stationData, resp, err = app.esi.ESI.UniverseApi.GetUniverseStationsStationId(cctx, int32(structureId), nil)
+			var stationData esi.GetUniverseStationsStationIdOk
+			stationData, _, err = esiCall(ctx, logger, func(ctx context.Context) (esi.GetUniverseStationsStationIdOk, *http.Response, error) {
+				return app.esi.ESI.UniverseApi.GetUniverseStationsStationId(ctx, int32(structureId), nil)
+			})
+			structureData = esi.GetUniverseStructuresStructureIdOk{
+				Name:          stationData.Name,
+				OwnerId:       stationData.Owner,
+				Position:      esi.GetUniverseStructuresStructureIdPosition(stationData.Position),
+				SolarSystemId: stationData.SystemId,
+				TypeId:        stationData.TypeId,
+			}
 		case glue.LocationType_Item:
-			structureData, resp, err = app.esi.ESI.UniverseApi.GetUniverseStructuresStructureId(cctx, structureId, nil)
-		case glue.LocationType_SolarSystem:
-			// noop
+			structureData, _, err = esiCall(ctx, logger, func(ctx context.Context) (esi.GetUniverseStructuresStructureIdOk, *http.Response, error) {
+				return app.esi.ESI.UniverseApi.GetUniverseStructuresStructureId(ctx, structureId, nil)
+			})
 		default:
 			logger.Warn("trying to get station data from solar system or other location", zap.Int64("id", structureId), zap.String("type", string(structureType)))
 			continue
 		}
 
 		if err != nil {
-			body := parseEsiError(err)
-			logger.Error("error fetching structure data", zap.Int64("structure_id", structureId), zap.String("body", body), zap.Error(err))
-			if attempt > 5 {
-				return nil
-			}
-			i--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			logger.Error("error fetching structure data", zap.Int64("structre_id", structureId), zap.String("status", resp.Status), zap.Error(err), zap.String("body", string(body)))
-			if attempt > 5 {
-				return nil
-			}
-			i--
-			attempt++
-			time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
-			resp.Body.Close()
+			logger.Error("error fetching structure data", zap.Int64("structure_id", structureId), zap.Error(err))
 			continue
 		}
 
-		if structureType == glue.LocationType_Station {
-			structureData = esi.GetUniverseStructuresStructureIdOk{
-				Name:          stationData.Name,
-				OwnerId:       stationData.Owner,
-				Position:      esi.GetUniverseStructuresStructureIdPosition(stationData.Position),
-				SolarSystemId: stationData.SystemId,
-				TypeId:        stationData.TypeId,
-			}
-		}
-
 		structures[structureId] = structureData
-		attempt = 1
-		resp.Body.Close()
 	}
 
 	return structures
 }
 
-func (app *app) fetchCorpItemNames(ctx context.Context, logger *zap.Logger, itemIds []int64) map[int64]string {
+func (app *app) fetchCorpItemNames(ctx context.Context, logger *zap.Logger, corporationId int32, itemIds []int64) map[int64]string {
 	slices.Sort(itemIds)
 	itemIds = slices.Compact(itemIds)
 
@@ -467,22 +544,14 @@ func (app *app) fetchCorpItemNames(ctx context.Context, logger *zap.Logger, item
 		return names
 	}
 
-	chunks := slices.Chunk(itemIds, 1000)
-	for chunk := range chunks {
-		cctx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-		defer cancel()
-
-		namePage, resp, err := app.esi.ESI.AssetsApi.PostCorporationsCorporationIdAssetsNames(cctx, app.config.AdminCorp, chunk, nil)
+	for chunk := range slices.Chunk(itemIds, 1000) {
+		namePage, _, err := esiCall(ctx, logger, func(ctx context.Context) ([]esi.PostCorporationsCorporationIdAssetsNames200Ok, *http.Response, error) {
+			return app.esi.ESI.AssetsApi.PostCorporationsCorporationIdAssetsNames(ctx, corporationId, chunk, nil)
+		})
 		if err != nil {
-			body := parseEsiError(err)
-			logger.Error("error fetching item names", zap.String("body", body), zap.Error(err))
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			logger.Error("error fetching item names", zap.String("status", resp.Status), zap.Error(err), zap.String("body", string(body)))
+			logger.Error("error fetching item names", zap.Error(err))
 			continue
 		}
-		defer resp.Body.Close()
 
 		for _, v := range namePage {
 			names[v.ItemId] = v.Name
@@ -492,26 +561,16 @@ func (app *app) fetchCorpItemNames(ctx context.Context, logger *zap.Logger, item
 	return names
 }
 
-func (app *app) fetchCorpHangarNames(ctx context.Context, logger *zap.Logger) []string {
-	reqCtx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
-	defer cancel()
-
+func (app *app) fetchCorpHangarNames(ctx context.Context, logger *zap.Logger, corporationId int32) []string {
 	out := []string{"Division 1", "Division 2", "Division 3", "Division 4", "Division 5", "Division 6", "Division 7"}
 
-	divisions, resp, err := app.esi.ESI.CorporationApi.GetCorporationsCorporationIdDivisions(reqCtx, app.config.AdminCorp, nil)
+	divisions, _, err := esiCall(ctx, logger, func(ctx context.Context) (esi.GetCorporationsCorporationIdDivisionsOk, *http.Response, error) {
+		return app.esi.ESI.CorporationApi.GetCorporationsCorporationIdDivisions(ctx, corporationId, nil)
+	})
 	if err != nil {
-		body := parseEsiError(err)
-		logger.Error("error fetching corp divisions", zap.String("body", body), zap.Error(err))
-		return out
-	} else if resp.StatusCode != http.StatusOK {
-		// some other error happened. print to logs
-		defer resp.Body.Close()
-		var buf []byte
-		resp.Body.Read(buf)
-		logger.Warn("status not 200", zap.String("status_code", resp.Status), zap.String("body", string(buf)))
+		logger.Error("error fetching corp divisions", zap.Error(err))
 		return out
 	}
-	defer resp.Body.Close()
 
 	for _, v := range divisions.Hangar {
 		out[int(v.Division)-1] = v.Name
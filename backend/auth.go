@@ -11,6 +11,7 @@ import (
 
 	"github.com/AlHeamer/brave-bpc/glue"
 	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
 	"github.com/gorilla/sessions"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
@@ -181,9 +182,11 @@ func (app *app) callback(logger *zap.Logger, w http.ResponseWriter, r *http.Requ
 	// esi get character corp and alliance
 	tokSrc := ssoAuth.TokenSource(token)
 	esiCtx := context.WithValue(context.Background(), goesi.ContextOAuth2, tokSrc)
-	affiliation, resp, err := app.esi.ESI.CharacterApi.PostCharactersAffiliation(esiCtx, []int32{claims.CharacterId}, nil)
-	if err != nil || resp.StatusCode != http.StatusOK || len(affiliation) != 1 {
-		logger.Error("error getting character affiliations", zap.Int("affiliation_length", len(affiliation)), zap.String("status", resp.Status), zap.Error(err))
+	affiliation, _, err := esiCall(esiCtx, logger, func(ctx context.Context) ([]esi.PostCharactersAffiliation200Ok, *http.Response, error) {
+		return app.esi.ESI.CharacterApi.PostCharactersAffiliation(ctx, []int32{claims.CharacterId}, nil)
+	})
+	if err != nil || len(affiliation) != 1 {
+		logger.Error("error getting character affiliations", zap.Int("affiliation_length", len(affiliation)), zap.Error(err))
 		http.Error(w, "error getting character affiliations", http.StatusInternalServerError)
 		return
 	}
@@ -351,6 +354,7 @@ func (app *app) addScopeToAccount(w http.ResponseWriter, r *http.Request) {
 	// create rows with refresh token
 	app.doLogin(w, r, []string{
 		string(glue.EsiScope_AssetsReadCorporationAssets_v1),
+		string(glue.EsiScope_ContractsReadCorporationContracts_v1),
 		string(glue.EsiScope_CorporationsReadBlueprints_v1),
 		string(glue.EsiScope_CorporationsReadDivisions_v1),
 		string(glue.EsiScope_IndustryReadCorporationJobs_v1),
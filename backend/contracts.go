@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/antihax/goesi/optional"
+	"go.uber.org/zap"
+)
+
+const (
+	contractType_ItemExchange = "item_exchange"
+
+	contractMatch_Completed = "completed" // requisition was auto-completed
+	contractMatch_Mismatch  = "mismatch"  // assignee has an open requisition, but the items differ
+)
+
+// requisitionContract records a corporation contract which has been matched against requisitions
+type requisitionContract struct {
+	ContractId     int32     `json:"contract_id"`
+	RequisitionId  int64     `json:"requisition_id,omitempty"`
+	Match          string    `json:"match"`
+	CorporationId  int32     `json:"corporation_id"` // source corp which issued the contract
+	IssuerId       int32     `json:"issuer_id,omitempty"`
+	AssigneeId     int32     `json:"assignee_id,omitempty"`
+	ContractStatus string    `json:"contract_status,omitempty"`
+	Notes          string    `json:"notes,omitempty"`
+	ProcessedAt    time.Time `json:"processed_at,omitzero"`
+}
+
+// contractStore is the part of the dao used to match contracts
+type contractStore interface {
+	listRequisitionOrders(characterId int32, status requisitionStatus) ([]requisitionOrder, error)
+	listProcessedContracts(contractIds []int32) (map[int32]bool, error)
+	completeRequisitionByContract(reqId int64, actor *user, notes requisitionNotes, blueprints []requestedBlueprint, status requisitionStatus, contract requisitionContract) error
+	flagContractMismatch(actor *user, contract requisitionContract) error
+}
+
+// systemUser is the actor recorded for changes made by background jobs
+func systemUser(name string) *user {
+	return &user{CharacterName: name}
+}
+
+// syncCorpContracts matches item exchange contracts issued by members of a source corp against open requisitions.
+// Accepted contracts which deliver the requested number of each blueprint complete the requisition, otherwise they
+// are flagged as a mismatch for a worker to resolve.
+func (app *app) syncCorpContracts(ctx context.Context, logger *zap.Logger, store contractStore, corporationId int32) error {
+	start := time.Now()
+	logger = logger.Named("contracts")
+
+	contracts, err := app.fetchCorpContracts(ctx, logger, corporationId)
+	if err != nil {
+		return err
+	}
+
+	open, err := store.listRequisitionOrders(0, requisitionStatus_Open)
+	if err != nil {
+		return fmt.Errorf("error listing open requisitions: %w", err)
+	}
+
+	byCharacter := map[int32][]requisitionOrder{}
+	for _, req := range open {
+		byCharacter[req.CharacterId] = append(byCharacter[req.CharacterId], req)
+	}
+
+	var candidates []esi.GetCorporationsCorporationIdContracts200Ok
+	for _, c := range contracts {
+		// the corp is also listed as party to contracts assigned to it by outsiders
+		if c.Type_ != contractType_ItemExchange || !deliveredContractStatus(c.Status) || c.IssuerCorporationId != corporationId {
+			continue
+		}
+		if _, ok := byCharacter[c.AssigneeId]; !ok {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	contractIds := make([]int32, len(candidates))
+	for i, c := range candidates {
+		contractIds[i] = c.ContractId
+	}
+	processed, err := store.listProcessedContracts(contractIds)
+	if err != nil {
+		return fmt.Errorf("error listing processed contracts: %w", err)
+	}
+
+	var completed, mismatched int
+	for _, c := range candidates {
+		// an earlier contract may have completed the assignee's only open requisition
+		if processed[c.ContractId] || len(byCharacter[c.AssigneeId]) == 0 {
+			continue
+		}
+
+		items, err := app.fetchCorpContractItems(ctx, logger, corporationId, c.ContractId)
+		if err != nil {
+			logger.Warn("error fetching contract items", zap.Int32("contract_id", c.ContractId), zap.Error(err))
+			continue
+		}
+
+		record := requisitionContract{
+			ContractId:     c.ContractId,
+			CorporationId:  corporationId,
+			IssuerId:       c.IssuerId,
+			AssigneeId:     c.AssigneeId,
+			ContractStatus: c.Status,
+		}
+
+		delivered := contractBlueprintCounts(items)
+		reqs := byCharacter[c.AssigneeId]
+		idx := slices.IndexFunc(reqs, func(req requisitionOrder) bool {
+			return describeContractMismatch(requestedBlueprintCounts(req.Blueprints), delivered) == ""
+		})
+
+		if idx >= 0 {
+			req := reqs[idx]
+			record.RequisitionId = req.Id
+			record.Match = contractMatch_Completed
+			record.Notes = unverifiedContractQuality(req.Blueprints)
+
+			blueprints, status, err := fulfill(req.Blueprints, nil)
+			if err != nil {
+				logger.Error("error fulfilling requisition", zap.Int64("requisition_id", req.Id), zap.Error(err))
+				continue
+			}
+
+			actor := systemUser(fmt.Sprintf("contract %d", c.ContractId))
+			notes := requisitionNotes{Private: fmt.Sprintf("auto-completed by contract %d issued by %d", c.ContractId, c.IssuerId)}
+			if record.Notes != "" {
+				notes.Private += ", check the quality of " + record.Notes
+			}
+			if err = store.completeRequisitionByContract(req.Id, actor, notes, blueprints, status, record); errors.Is(err, errRequisitionChanged) {
+				logger.Info("requisition or contract already processed", zap.Int64("requisition_id", req.Id), zap.Int32("contract_id", c.ContractId))
+				continue
+			} else if err != nil {
+				logger.Error("error completing requisition by contract", zap.Int64("requisition_id", req.Id), zap.Int32("contract_id", c.ContractId), zap.Error(err))
+				continue
+			}
+
+			byCharacter[c.AssigneeId] = slices.Delete(reqs, idx, idx+1)
+			completed++
+			continue
+		}
+
+		// flag against the oldest open requisition for the assignee
+		record.RequisitionId = reqs[0].Id
+		record.Match = contractMatch_Mismatch
+		record.Notes = describeContractMismatch(requestedBlueprintCounts(reqs[0].Blueprints), delivered)
+		if err = store.flagContractMismatch(systemUser(fmt.Sprintf("contract %d", c.ContractId)), record); errors.Is(err, errRequisitionChanged) {
+			continue
+		} else if err != nil {
+			logger.Error("error recording contract mismatch", zap.Int32("contract_id", c.ContractId), zap.Error(err))
+			continue
+		}
+		mismatched++
+	}
+
+	logger.Info("synced corp contracts",
+		zap.Int("contracts", len(contracts)),
+		zap.Int("candidates", len(candidates)),
+		zap.Int("completed", completed),
+		zap.Int("mismatched", mismatched),
+		zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// deliveredContractStatus reports whether a contract with status has handed over its items. Outstanding
+// contracts may yet be rejected or expire, they are left unprocessed until the assignee accepts them.
+func deliveredContractStatus(status string) bool {
+	switch status {
+	case "finished", "finished_issuer", "finished_contractor":
+		return true
+	}
+	return false
+}
+
+// contractBlueprintCounts counts the blueprint copies included in a contract by type
+func contractBlueprintCounts(items []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok) map[int32]int32 {
+	counts := map[int32]int32{}
+	for _, item := range items {
+		if !item.IsIncluded || item.RawQuantity != -2 {
+			continue
+		}
+		counts[item.TypeId] += max(item.Quantity, 1)
+	}
+	return counts
+}
+
+// requestedBlueprintCounts counts the blueprint copies requested by type
+func requestedBlueprintCounts(blueprints []requestedBlueprint) map[int32]int32 {
+	counts := map[int32]int32{}
+	for _, bp := range blueprints {
+		counts[bp.TypeId] += bp.count()
+	}
+	return counts
+}
+
+// unverifiedContractQuality lists the lines asking for a specific quality. ESI doesn't report the runs, ME or
+// TE of contract items, so a contract is matched on its counts and these are left for a worker to check.
+func unverifiedContractQuality(requested []requestedBlueprint) string {
+	var lines []string
+	for _, bp := range requested {
+		if !bp.Any {
+			lines = append(lines, fmt.Sprintf("type %d %d runs ME %d TE %d", bp.TypeId, bp.Runs, bp.MaterialEfficiency, bp.TimeEfficiency))
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+func describeContractMismatch(requested map[int32]int32, delivered map[int32]int32) string {
+	typeIds := slices.Sorted(maps.Keys(requested))
+	for typeId := range delivered {
+		if _, ok := requested[typeId]; !ok {
+			typeIds = append(typeIds, typeId)
+		}
+	}
+
+	var diffs []string
+	for _, typeId := range typeIds {
+		if requested[typeId] != delivered[typeId] {
+			diffs = append(diffs, fmt.Sprintf("type %d requested %d delivered %d", typeId, requested[typeId], delivered[typeId]))
+		}
+	}
+	return strings.Join(diffs, "; ")
+}
+
+func (app *app) fetchCorpContracts(ctx context.Context, logger *zap.Logger, corporationId int32) ([]esi.GetCorporationsCorporationIdContracts200Ok, error) {
+	start := time.Now()
+	contracts, err := esiPages(ctx, logger, func(ctx context.Context, page int32) ([]esi.GetCorporationsCorporationIdContracts200Ok, *http.Response, error) {
+		return app.esi.ESI.ContractsApi.GetCorporationsCorporationIdContracts(ctx, corporationId,
+			&esi.GetCorporationsCorporationIdContractsOpts{
+				Page: optional.NewInt32(page),
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching contracts: %w", err)
+	}
+
+	logger.Debug("fetched contracts", zap.Int("contracts", len(contracts)), zap.Duration("duration", time.Since(start)))
+	return contracts, nil
+}
+
+func (app *app) fetchCorpContractItems(ctx context.Context, logger *zap.Logger, corporationId int32, contractId int32) ([]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok, error) {
+	items, _, err := esiCall(ctx, logger, func(ctx context.Context) ([]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok, *http.Response, error) {
+		return app.esi.ESI.ContractsApi.GetCorporationsCorporationIdContractsContractIdItems(ctx, contractId, corporationId, nil)
+	})
+	return items, err
+}
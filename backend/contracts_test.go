@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+const testSourceCorp = 98000001
+
+// fakeContractEsi serves the corporation contract endpoints of esi
+type fakeContractEsi struct {
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok
+	items     map[int32][]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok
+}
+
+func (f *fakeContractEsi) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	writeJson := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Errorf("error encoding response: %v", err)
+		}
+	}
+
+	mux.HandleFunc("GET /v1/corporations/{corporation_id}/contracts/", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, f.contracts)
+	})
+	mux.HandleFunc("GET /v1/corporations/{corporation_id}/contracts/{contract_id}/items/", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("contract_id"), 10, 32)
+		items, ok := f.items[int32(id)]
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJson(w, items)
+	})
+	return mux
+}
+
+// fakeContractStore records what syncCorpContracts would write to the database
+type fakeContractStore struct {
+	open      []requisitionOrder
+	processed map[int32]bool
+	completed map[int64]requisitionContract
+	flagged   []requisitionContract
+}
+
+func (s *fakeContractStore) listRequisitionOrders(characterId int32, status requisitionStatus) ([]requisitionOrder, error) {
+	return s.open, nil
+}
+
+func (s *fakeContractStore) listProcessedContracts(contractIds []int32) (map[int32]bool, error) {
+	return s.processed, nil
+}
+
+func (s *fakeContractStore) completeRequisitionByContract(reqId int64, actor *user, notes requisitionNotes, blueprints []requestedBlueprint, status requisitionStatus, contract requisitionContract) error {
+	s.completed[reqId] = contract
+	return nil
+}
+
+func (s *fakeContractStore) flagContractMismatch(actor *user, contract requisitionContract) error {
+	s.flagged = append(s.flagged, contract)
+	return nil
+}
+
+func newContractTestApp(t *testing.T, fake *fakeContractEsi) *app {
+	server := httptest.NewServer(fake.handler(t))
+	t.Cleanup(server.Close)
+
+	client := goesi.NewAPIClient(server.Client(), "brave-bpc test")
+	client.ChangeBasePath(server.URL)
+	return &app{esi: client}
+}
+
+func itemExchange(contractId, issuerCorp, assignee int32, status string) esi.GetCorporationsCorporationIdContracts200Ok {
+	return esi.GetCorporationsCorporationIdContracts200Ok{
+		ContractId:          contractId,
+		Type_:               contractType_ItemExchange,
+		Status:              status,
+		IssuerId:            90000001,
+		IssuerCorporationId: issuerCorp,
+		AssigneeId:          assignee,
+	}
+}
+
+func copies(typeId, quantity int32) esi.GetCorporationsCorporationIdContractsContractIdItems200Ok {
+	return esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+		IsIncluded:  true,
+		TypeId:      typeId,
+		Quantity:    quantity,
+		RawQuantity: -2,
+	}
+}
+
+func TestSyncCorpContracts(t *testing.T) {
+	const alice, bob, carol, dave = 91000001, 91000002, 91000003, 91000004
+
+	fake := &fakeContractEsi{
+		contracts: []esi.GetCorporationsCorporationIdContracts200Ok{
+			itemExchange(1, testSourceCorp, alice, "finished"),   // exactly what was asked for
+			itemExchange(2, testSourceCorp, bob, "finished"),     // one copy short
+			itemExchange(3, testSourceCorp, carol, "finished"),   // right count, quality left for a worker to check
+			itemExchange(4, 98000002, bob, "finished"),           // matches, but issued by someone outside the source corp
+			itemExchange(5, testSourceCorp, dave, "outstanding"), // matches, but not accepted yet
+		},
+		items: map[int32][]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+			1: {copies(1000, 2), copies(2000, 1)},
+			2: {copies(1000, 1)},
+			3: {copies(3000, 1)},
+			4: {copies(5000, 1)},
+			5: {copies(6000, 1)},
+		},
+	}
+	store := &fakeContractStore{
+		open: []requisitionOrder{
+			{Id: 10, CharacterId: alice, Blueprints: []requestedBlueprint{
+				{TypeId: 1000, Quantity: 2, Any: true},
+				{TypeId: 2000, Quantity: 1, Any: true},
+			}},
+			{Id: 20, CharacterId: bob, Blueprints: []requestedBlueprint{{TypeId: 1000, Quantity: 2, Any: true}}},
+			{Id: 21, CharacterId: bob, Blueprints: []requestedBlueprint{{TypeId: 5000, Quantity: 1, Any: true}}},
+			{Id: 30, CharacterId: carol, Blueprints: []requestedBlueprint{{TypeId: 3000, Runs: 10, MaterialEfficiency: 10, TimeEfficiency: 20, Quantity: 1}}},
+			{Id: 40, CharacterId: dave, Blueprints: []requestedBlueprint{{TypeId: 6000, Quantity: 1, Any: true}}},
+		},
+		processed: map[int32]bool{},
+		completed: map[int64]requisitionContract{},
+	}
+
+	app := newContractTestApp(t, fake)
+	if err := app.syncCorpContracts(context.Background(), zap.NewNop(), store, testSourceCorp); err != nil {
+		t.Fatal(err)
+	}
+
+	wantCompleted := map[int64]struct {
+		contractId int32
+		notes      string
+	}{
+		10: {1, ""},
+		30: {3, "type 3000 10 runs ME 10 TE 20"},
+	}
+	if len(store.completed) != len(wantCompleted) {
+		t.Fatalf("completed %d requisitions, want %d", len(store.completed), len(wantCompleted))
+	}
+	for reqId, w := range wantCompleted {
+		if got, ok := store.completed[reqId]; !ok || got.ContractId != w.contractId || got.Match != contractMatch_Completed || got.Notes != w.notes {
+			t.Errorf("requisition %d completed by %+v, want contract %d with notes %q", reqId, got, w.contractId, w.notes)
+		}
+	}
+
+	want := map[int32]struct {
+		requisitionId int64
+		notes         string
+	}{
+		2: {20, "type 1000 requested 2 delivered 1"},
+	}
+	if len(store.flagged) != len(want) {
+		t.Fatalf("flagged %d contracts, want %d", len(store.flagged), len(want))
+	}
+	for _, got := range store.flagged {
+		w, ok := want[got.ContractId]
+		if !ok {
+			t.Errorf("contract %d flagged unexpectedly", got.ContractId)
+			continue
+		}
+		if got.RequisitionId != w.requisitionId || got.Match != contractMatch_Mismatch || got.Notes != w.notes {
+			t.Errorf("contract %d flagged as %+v, want requisition %d with notes %q", got.ContractId, got, w.requisitionId, w.notes)
+		}
+	}
+}
+
+func TestDescribeContractMismatch(t *testing.T) {
+	tests := []struct {
+		requested map[int32]int32
+		delivered map[int32]int32
+		want      string
+	}{
+		{map[int32]int32{1: 2}, map[int32]int32{1: 2}, ""},
+		{map[int32]int32{1: 2}, map[int32]int32{1: 1}, "type 1 requested 2 delivered 1"},
+		{map[int32]int32{1: 1}, map[int32]int32{1: 1, 2: 3}, "type 2 requested 0 delivered 3"},
+		{map[int32]int32{2: 1, 1: 1}, map[int32]int32{}, "type 1 requested 1 delivered 0; type 2 requested 1 delivered 0"},
+	}
+
+	for _, tt := range tests {
+		if got := describeContractMismatch(tt.requested, tt.delivered); got != tt.want {
+			t.Errorf("describeContractMismatch(%v, %v) = %q, want %q", tt.requested, tt.delivered, got, tt.want)
+		}
+	}
+}
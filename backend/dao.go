@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AlHeamer/brave-bpc/sqlparams"
 	"github.com/gorilla/sessions"
@@ -15,6 +17,12 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// errRequisitionChanged is returned when a requisition update didn't match the expected status or lock
+var errRequisitionChanged = errors.New("requisition status or lock changed")
+
+// errInvalidParentComment is returned when replying to a comment which doesn't belong to the requisition
+var errInvalidParentComment = errors.New("parent comment not found")
+
 type scopeRefreshPair struct {
 	token string
 	scope string
@@ -333,19 +341,204 @@ func (d *dao) runMigrations(logger *zap.Logger, migrateDown bool) {
 	}
 }
 
-func (dao *dao) createRequisition(characterId int32, characterName string, blueprints []requestedBlueprint) error {
+// createRequisition inserts a new requisition and returns its id.
+// check is called inside the transaction with every open requisition, see lockOpenRequisitions.
+// This prevents concurrent requisitions from reserving the same blueprints. Returning an error
+// from check aborts the insert, check may also modify blueprints before they are stored.
+func (dao *dao) createRequisition(owner *user, blueprints []requestedBlueprint, check requisitionCheck) (int64, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = lockAccount(tx, owner.UserId); err != nil {
+		return 0, err
+	}
+	if err = lockOpenRequisitions(tx, check); err != nil {
+		return 0, err
+	}
+
+	// marshalled after check, which may allocate stock to the lines
 	bytes, err := json.Marshal(blueprints)
 	if err != nil {
-		return fmt.Errorf("error marshalling json: %w", err)
+		return 0, fmt.Errorf("error marshalling json: %w", err)
 	}
 
-	_, err = dao.db.Exec(`
+	res, err := tx.Exec(`
 INSERT INTO requisition_order
 (character_id, blueprints, updated_by, character_name)
 VALUES (?,?,?,?)
-`, characterId, bytes, characterName, characterName)
+`, owner.CharacterId, bytes, owner.CharacterName, owner.CharacterName)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting requisition: %w", err)
+	}
 
-	return err
+	reqId, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting requisition id: %w", err)
+	}
+
+	if err = insertRequisitionVersion(tx, reqId, 1, bytes, owner); err != nil {
+		return 0, err
+	}
+
+	if err = replaceRequisitionTypes(tx, reqId, blueprints); err != nil {
+		return 0, err
+	}
+
+	ev := newRequisitionEvent(reqId, requisitionAction_Create, owner, requisitionStatus_Unknown, requisitionStatus_Open, requisitionNotes{})
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return reqId, nil
+}
+
+// editRequisition replaces the blueprints of an open, unlocked requisition owned by the actor.
+// The previous blueprints are kept in requisition_version. check behaves as in createRequisition.
+func (dao *dao) editRequisition(reqId int64, actor *user, blueprints []requestedBlueprint, check requisitionCheck) (int32, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = lockAccount(tx, actor.UserId); err != nil {
+		return 0, err
+	}
+	if err = lockOpenRequisitions(tx, check); err != nil {
+		return 0, err
+	}
+
+	bytes, err := json.Marshal(blueprints)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling json: %w", err)
+	}
+
+	res, err := tx.Exec(`
+UPDATE requisition_order
+SET
+	blueprints=?,
+	version=version+1,
+	updated_at=NOW(),
+	updated_by=?
+WHERE
+	id=? AND
+	character_id=? AND
+	requisition_status=? AND
+	NOT EXISTS (SELECT 1 FROM requisition_lock WHERE requisition_id=? AND expires_at > NOW())
+`, bytes, actor.CharacterName, reqId, actor.CharacterId, requisitionStatus_Open, reqId)
+	if err != nil {
+		return 0, fmt.Errorf("error updating requisition: %w", err)
+	}
+	if err = expectAffected(res, errRequisitionChanged); err != nil {
+		return 0, err
+	}
+
+	var version int32
+	if err = tx.QueryRow(`SELECT version FROM requisition_order WHERE id=?`, reqId).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading version: %w", err)
+	}
+
+	if err = insertRequisitionVersion(tx, reqId, version, bytes, actor); err != nil {
+		return 0, err
+	}
+
+	if err = replaceRequisitionTypes(tx, reqId, blueprints); err != nil {
+		return 0, err
+	}
+
+	ev := newRequisitionEvent(reqId, requisitionAction_Edit, actor, requisitionStatus_Open, requisitionStatus_Open, requisitionNotes{})
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return 0, err
+	}
+
+	return version, tx.Commit()
+}
+
+// requisitionCheck validates a change to a requisition against the open requisitions. q reads within the
+// transaction holding them locked.
+type requisitionCheck func(q querier, open []requisitionOrder) error
+
+// lockAccount locks the user row of an account until tx completes, serialising changes to its requisitions
+// even when it has none open
+func lockAccount(tx *sql.Tx, userId int64) error {
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM user WHERE id=? FOR UPDATE`, userId).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error locking account: %w", err)
+	}
+	return nil
+}
+
+// lockOpenRequisitions selects every open requisition for update and passes them to check.
+// The rows stay locked until tx completes, serialising changes to blueprint reservations.
+func lockOpenRequisitions(tx *sql.Tx, check requisitionCheck) error {
+	rows, err := tx.Query(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE requisition_status = ?
+FOR UPDATE
+`, requisitionStatus_Open)
+	if err != nil {
+		return fmt.Errorf("error locking open requisitions: %w", err)
+	}
+
+	open, err := scanRequisitionOrders(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	return check(tx, open)
+}
+
+func insertRequisitionVersion(ex execer, reqId int64, version int32, bpjs []byte, actor *user) error {
+	_, err := ex.Exec(`
+INSERT INTO requisition_version
+(requisition_id, version, blueprints, created_by)
+VALUES (?,?,?,?)
+`, reqId, version, bpjs, actor.CharacterName)
+	if err != nil {
+		return fmt.Errorf("error inserting requisition version: %w", err)
+	}
+	return nil
+}
+
+func (dao *dao) listRequisitionVersions(reqId int64) ([]requisitionVersion, error) {
+	rows, err := dao.db.Query(`
+SELECT requisition_id, version, blueprints, created_at, created_by
+FROM requisition_version
+WHERE requisition_id=?
+ORDER BY version ASC
+`, reqId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []requisitionVersion{}
+	for rows.Next() {
+		var v requisitionVersion
+		var bpjs []byte
+		if err = rows.Scan(&v.RequisitionId, &v.Version, &bpjs, &v.CreatedAt, &v.CreatedBy); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		if err = json.Unmarshal(bpjs, &v.Blueprints); err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return versions, nil
 }
 
 func (dao *dao) listRequisitionOrders(characterId int32, status requisitionStatus) ([]requisitionOrder, error) {
@@ -362,7 +555,7 @@ func (dao *dao) listRequisitionOrders(characterId int32, status requisitionStatu
 	}
 
 	rows, err := dao.db.Query(`
-SELECT *
+SELECT `+requisitionColumns+`
 FROM requisition_order
 WHERE `+filter+`
 ORDER BY created_at ASC
@@ -370,39 +563,182 @@ ORDER BY created_at ASC
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	reqs := []requisitionOrder{}
-	for rows.Next() {
-		var notes sql.NullString
-		var req requisitionOrder
-		var bpjs []byte
-		if err = rows.Scan(&req.Id, &req.CharacterId, &req.Status, &req.CreatedAt, &req.UpdatedAt, &req.UpdatedBy, &bpjs, &notes, &req.CharacterName); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
+	return scanRequisitionOrders(rows)
+}
+
+func (dao *dao) getRequisition(reqId int64) (*requisitionOrder, error) {
+	return scanRequisitionOrder(dao.db.QueryRow(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE id=?
+`, reqId))
+}
+
+// queryRequisitionOrders returns a page of requisitions matching filter, and the cursor for the next page
+// which is empty on the last page
+func (dao *dao) queryRequisitionOrders(filter *requisitionFilter) ([]requisitionOrder, string, error) {
+	params := sqlparams.New()
+	where := []string{"1=1"}
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]any, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = status
 		}
+		where = append(where, "requisition_status IN ("+params.AddParams(statuses...)+")")
+	}
+	if filter.CharacterId > 0 {
+		where = append(where, "character_id="+params.AddParam(filter.CharacterId))
+	}
+	if filter.UpdatedBy != "" {
+		where = append(where, "updated_by="+params.AddParam(filter.UpdatedBy))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= "+params.AddParam(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		where = append(where, "created_at < "+params.AddParam(filter.CreatedTo))
+	}
+	if filter.TypeId > 0 {
+		where = append(where, "id IN (SELECT requisition_id FROM requisition_type WHERE type_id="+params.AddParam(filter.TypeId)+")")
+	}
+	if filter.LockedBy > 0 {
+		where = append(where, "id IN (SELECT requisition_id FROM requisition_lock WHERE character_id="+params.AddParam(filter.LockedBy)+" AND expires_at > NOW())")
+	}
 
-		req.PublicNotes = notes.String
-		if err = json.Unmarshal(bpjs, &req.Blueprints); err != nil {
-			return nil, fmt.Errorf("error unmarshalling json: %w", err)
+	col, cmp, dir := filter.Sort.column(), ">", "ASC"
+	if filter.Sort.descending() {
+		cmp, dir = "<", "DESC"
+	}
+	if c := filter.Cursor; c != nil {
+		where = append(where, fmt.Sprintf("(%s %s %s OR (%s = %s AND id %s %s))",
+			col, cmp, params.AddParam(c.at), col, params.AddParam(c.at), cmp, params.AddParam(c.id)))
+	}
+
+	rows, err := dao.db.Query(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE `+strings.Join(where, " AND ")+`
+ORDER BY `+col+` `+dir+`, id `+dir+`
+LIMIT `+params.AddParam(filter.Limit+1), params...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	reqs, err := scanRequisitionOrders(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(reqs) <= filter.Limit {
+		return reqs, "", nil
+	}
+
+	reqs = reqs[:filter.Limit]
+	return reqs, newRequisitionCursor(filter.Sort, reqs[len(reqs)-1]).String(), nil
+}
+
+// replaceRequisitionTypes indexes the types requested by a requisition, see dao.queryRequisitionOrders
+func replaceRequisitionTypes(ex execer, reqId int64, blueprints []requestedBlueprint) error {
+	if _, err := ex.Exec(`DELETE FROM requisition_type WHERE requisition_id=?`, reqId); err != nil {
+		return fmt.Errorf("error clearing requisition types: %w", err)
+	}
+
+	params := sqlparams.New()
+	seen := map[int32]bool{}
+	var values []string
+	for _, bp := range blueprints {
+		if seen[bp.TypeId] {
+			continue
 		}
+		seen[bp.TypeId] = true
+		values = append(values, "("+params.AddParams(reqId, bp.TypeId)+")")
+	}
+	if len(values) == 0 {
+		return nil
+	}
 
-		reqs = append(reqs, req)
+	if _, err := ex.Exec(`
+INSERT INTO requisition_type
+(requisition_id, type_id)
+VALUES `+strings.Join(values, ","), params...); err != nil {
+		return fmt.Errorf("error inserting requisition types: %w", err)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+	return nil
+}
+
+// lockAccountRequisitions returns requisitions made by any character linked to userId which are either open
+// or were created after since, locking them until the transaction q belongs to completes.
+// characterId is included in case the character has no toon record.
+func lockAccountRequisitions(q querier, userId int64, characterId int32, since time.Time) ([]requisitionOrder, error) {
+	rows, err := q.Query(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE
+	(character_id = ? OR character_id IN (SELECT character_id FROM toon WHERE user_id = ?)) AND
+	(requisition_status = ? OR created_at >= ?)
+ORDER BY created_at ASC
+FOR UPDATE
+`, characterId, userId, requisitionStatus_Open, since)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return reqs, nil
+	return scanRequisitionOrders(rows)
 }
 
-func (dao *dao) getRequisition(reqId int64) (*requisitionOrder, error) {
+// listClosedRequisitionsSince returns requisitions created after since which were closed by a worker,
+// leaving out open ones and those canceled by their owner
+func (dao *dao) listClosedRequisitionsSince(since time.Time) ([]requisitionOrder, error) {
+	rows, err := dao.db.Query(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE
+	created_at >= ? AND
+	requisition_status NOT IN (?,?)
+ORDER BY created_at ASC
+`, since, requisitionStatus_Open, requisitionStatus_Canceled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRequisitionOrders(rows)
+}
+
+// listRequisitionsCreatedBetween returns requisitions of any status created in [from, to)
+func (dao *dao) listRequisitionsCreatedBetween(from time.Time, to time.Time) ([]requisitionOrder, error) {
+	rows, err := dao.db.Query(`
+SELECT `+requisitionColumns+`
+FROM requisition_order
+WHERE created_at >= ? AND created_at < ?
+ORDER BY created_at ASC
+`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRequisitionOrders(rows)
+}
+
+// requisitionColumns is the column order expected by scanRequisitionOrder
+const requisitionColumns = `id, character_id, requisition_status, created_at, updated_at, updated_by, blueprints, notes, private_notes, character_name, version, corporation_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRequisitionOrder(row rowScanner) (*requisitionOrder, error) {
 	var bpjs []byte
 	var req requisitionOrder
-	var notes sql.NullString
-	err := dao.db.QueryRow(`
-SELECT *
-FROM requisition_order
-WHERE id=?
-`, reqId).Scan(
+	var notes, privateNotes sql.NullString
+	var corporationId sql.NullInt32
+	err := row.Scan(
 		&req.Id,
 		&req.CharacterId,
 		&req.Status,
@@ -411,13 +747,18 @@ WHERE id=?
 		&req.UpdatedBy,
 		&bpjs,
 		&notes,
+		&privateNotes,
 		&req.CharacterName,
+		&req.Version,
+		&corporationId,
 	)
 	if err != nil {
 		return nil, err
 	}
 
 	req.PublicNotes = notes.String
+	req.PrivateNotes = privateNotes.String
+	req.CorporationId = corporationId.Int32
 
 	if err = json.Unmarshal(bpjs, &req.Blueprints); err != nil {
 		return nil, fmt.Errorf("error unmarshalling json: %w", err)
@@ -426,46 +767,1055 @@ WHERE id=?
 	return &req, nil
 }
 
-func (dao *dao) cancelRequisition(reqId int64, updatedBy string) error {
-	_, err := dao.db.Exec(`
-UPDATE requisition_order
-SET
-	requisition_status=?,
-	updated_at=NOW(),
-	updated_by=?
-WHERE
-	id=?
-`, requisitionStatus_Canceled, updatedBy, reqId)
+func scanRequisitionOrders(rows *sql.Rows) ([]requisitionOrder, error) {
+	reqs := []requisitionOrder{}
+	for rows.Next() {
+		req, err := scanRequisitionOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		reqs = append(reqs, *req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
 
-	return err
+	return reqs, nil
 }
 
-func (dao *dao) completeRequisition(reqId int64, updatedBy string, notes string) error {
-	_, err := dao.db.Exec(`
+func (dao *dao) cancelRequisition(reqId int64, actor *user) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
 UPDATE requisition_order
 SET
 	requisition_status=?,
-	notes=?,
 	updated_at=NOW(),
 	updated_by=?
 WHERE
-	id=?
-`, requisitionStatus_Completed, notes, updatedBy, reqId)
+	id=? AND
+	requisition_status=? AND
+	NOT EXISTS (SELECT 1 FROM requisition_lock WHERE requisition_id=? AND expires_at > NOW())
+`, requisitionStatus_Canceled, actor.CharacterName, reqId, requisitionStatus_Open, reqId)
+	if err != nil {
+		return err
+	}
+	if err = expectAffected(res, errRequisitionChanged); err != nil {
+		return err
+	}
 
-	return err
+	ev := newRequisitionEvent(reqId, requisitionAction_Cancel, actor, requisitionStatus_Open, requisitionStatus_Canceled, requisitionNotes{})
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// completeRequisition closes the requisition with status completed or partially completed.
+// blueprints must include the fulfillment of each line. corporationId is the source corp which handed them over, 0 if unknown.
+func (dao *dao) completeRequisition(reqId int64, actor *user, notes requisitionNotes, blueprints []requestedBlueprint, status requisitionStatus, corporationId int32) error {
+	bytes, err := json.Marshal(blueprints)
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %w", err)
+	}
+	return dao.closeLockedRequisition(reqId, actor, notes, bytes, requisitionAction_Complete, status, corporationId)
+}
+
+func (dao *dao) rejectRequisition(reqId int64, actor *user, notes requisitionNotes) error {
+	return dao.closeLockedRequisition(reqId, actor, notes, nil, requisitionAction_Reject, requisitionStatus_Rejected, 0)
 }
 
-func (dao *dao) rejectRequisition(reqId int64, updatedBy string, notes string) error {
-	_, err := dao.db.Exec(`
+// closeLockedRequisition moves an open requisition to status and releases its lock.
+// The requisition must be locked by the actor. Blueprints are replaced unless bpjs is nil.
+func (dao *dao) closeLockedRequisition(reqId int64, actor *user, notes requisitionNotes, bpjs []byte, action requisitionAction, status requisitionStatus, corporationId int32) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
 UPDATE requisition_order
 SET
 	requisition_status=?,
+	blueprints=COALESCE(?, blueprints),
 	notes=?,
+	private_notes=?,
+	corporation_id=?,
 	updated_at=NOW(),
 	updated_by=?
 WHERE
-	id=?
-`, requisitionStatus_Rejected, notes, updatedBy, reqId)
+	id=? AND
+	requisition_status=? AND
+	EXISTS (SELECT 1 FROM requisition_lock WHERE requisition_id=? AND character_id=? AND expires_at > NOW())
+`, status, bpjs, notes.Public, notes.Private, sql.NullInt32{Int32: corporationId, Valid: corporationId != 0}, actor.CharacterName, reqId, requisitionStatus_Open, reqId, actor.CharacterId)
+	if err != nil {
+		return err
+	}
+	if err = expectAffected(res, errRequisitionChanged); err != nil {
+		return err
+	}
 
-	return err
+	if _, err = tx.Exec(`DELETE FROM requisition_lock WHERE requisition_id=?`, reqId); err != nil {
+		return fmt.Errorf("error releasing lock: %w", err)
+	}
+
+	if err = insertRequisitionEvent(tx, newRequisitionEvent(reqId, action, actor, requisitionStatus_Open, status, notes)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// acquireRequisitionLock locks an open requisition for user until the lock expires.
+// Returns false if the requisition is already locked or no longer open.
+func (dao *dao) acquireRequisitionLock(reqId int64, user *user, expiry time.Duration) (bool, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+DELETE FROM requisition_lock
+WHERE requisition_id=? AND expires_at <= NOW()
+`, reqId); err != nil {
+		return false, fmt.Errorf("error clearing expired lock: %w", err)
+	}
+
+	res, err := tx.Exec(`
+INSERT IGNORE INTO requisition_lock
+(requisition_id, character_id, character_name, locked_at, expires_at)
+SELECT id, ?, ?, NOW(), DATE_ADD(NOW(), INTERVAL ? SECOND)
+FROM requisition_order
+WHERE id=? AND requisition_status=?
+`, user.CharacterId, user.CharacterName, int64(expiry.Seconds()), reqId, requisitionStatus_Open)
+	if err != nil {
+		return false, fmt.Errorf("error inserting lock: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return false, err
+	}
+
+	ev := newRequisitionEvent(reqId, requisitionAction_Lock, user, requisitionStatus_Open, requisitionStatus_Open, requisitionNotes{})
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// releaseRequisitionLock removes a lock held by the actor, or by anyone if force is set.
+// Returns false if there was no matching lock.
+func (dao *dao) releaseRequisitionLock(reqId int64, actor *user, force bool) (bool, error) {
+	params := sqlparams.New()
+	filter := "requisition_id=" + params.AddParam(reqId)
+	action := requisitionAction_ForceUnlock
+	if !force {
+		filter += " AND character_id=" + params.AddParam(actor.CharacterId)
+		action = requisitionAction_Unlock
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+DELETE FROM requisition_lock
+WHERE `+filter, params...)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	ev := newRequisitionEvent(reqId, action, actor, requisitionStatus_Open, requisitionStatus_Open, requisitionNotes{})
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// getRequisitionLock returns the current lock on a requisition, or nil if it is not locked
+func (dao *dao) getRequisitionLock(reqId int64) (*requisitionLock, error) {
+	var lock requisitionLock
+	err := dao.db.QueryRow(`
+SELECT requisition_id, character_id, character_name, locked_at, expires_at
+FROM requisition_lock
+WHERE requisition_id=? AND expires_at > NOW()
+`, reqId).Scan(&lock.RequisitionId, &lock.CharacterId, &lock.CharacterName, &lock.LockedAt, &lock.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// listRequisitionLocks returns all unexpired locks keyed by requisition id
+func (dao *dao) listRequisitionLocks() (map[int64]requisitionLock, error) {
+	rows, err := dao.db.Query(`
+SELECT requisition_id, character_id, character_name, locked_at, expires_at
+FROM requisition_lock
+WHERE expires_at > NOW()
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := map[int64]requisitionLock{}
+	for rows.Next() {
+		var lock requisitionLock
+		if err = rows.Scan(&lock.RequisitionId, &lock.CharacterId, &lock.CharacterName, &lock.LockedAt, &lock.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		locks[lock.RequisitionId] = lock
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return locks, nil
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// insertRequisitionEvent records an entry in the requisition history.
+// ex should be the transaction which performed the change.
+func insertRequisitionEvent(ex execer, ev requisitionEvent) error {
+	_, err := ex.Exec(`
+INSERT INTO requisition_event
+(requisition_id, action, character_id, character_name, old_status, new_status, notes, private_notes)
+VALUES (?,?,?,?,?,?,?,?)
+`, ev.RequisitionId, ev.Action, ev.CharacterId, ev.CharacterName, ev.OldStatus, ev.NewStatus, ev.Notes, ev.PrivateNotes)
+	if err != nil {
+		return fmt.Errorf("error inserting requisition event: %w", err)
+	}
+	return nil
+}
+
+func (dao *dao) listRequisitionEvents(reqId int64) ([]requisitionEvent, error) {
+	rows, err := dao.db.Query(`
+SELECT id, requisition_id, action, character_id, character_name, old_status, new_status, notes, private_notes, created_at
+FROM requisition_event
+WHERE requisition_id=?
+ORDER BY created_at ASC, id ASC
+`, reqId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []requisitionEvent{}
+	for rows.Next() {
+		var ev requisitionEvent
+		var notes, privateNotes sql.NullString
+		if err = rows.Scan(&ev.Id, &ev.RequisitionId, &ev.Action, &ev.CharacterId, &ev.CharacterName, &ev.OldStatus, &ev.NewStatus, &notes, &privateNotes, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		ev.Notes = notes.String
+		ev.PrivateNotes = privateNotes.String
+		events = append(events, ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return events, nil
+}
+
+// listRequisitionClosures returns complete and reject events recorded in [from, to),
+// along with when the requisition was created
+func (dao *dao) listRequisitionClosures(from time.Time, to time.Time) ([]requisitionClosure, error) {
+	rows, err := dao.db.Query(`
+SELECT e.requisition_id, e.action, e.character_id, e.character_name, e.old_status, e.new_status, e.created_at, o.created_at
+FROM requisition_event e
+JOIN requisition_order o ON o.id = e.requisition_id
+WHERE
+	e.action IN (?,?) AND
+	e.created_at >= ? AND e.created_at < ?
+ORDER BY e.created_at ASC, e.id ASC
+`, requisitionAction_Complete, requisitionAction_Reject, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	closures := []requisitionClosure{}
+	for rows.Next() {
+		var c requisitionClosure
+		if err = rows.Scan(&c.RequisitionId, &c.Action, &c.CharacterId, &c.CharacterName, &c.OldStatus, &c.NewStatus, &c.CreatedAt, &c.RequestedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		closures = append(closures, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return closures, nil
+}
+
+func (dao *dao) createRequisitionComment(c *requisitionComment) error {
+	var parentId sql.NullInt64
+	if c.ParentId > 0 {
+		parentId = sql.NullInt64{Int64: c.ParentId, Valid: true}
+	}
+
+	res, err := dao.db.Exec(`
+INSERT INTO requisition_comment
+(requisition_id, parent_id, character_id, character_name, body)
+SELECT ?,?,?,?,?
+FROM DUAL
+WHERE ? IS NULL OR EXISTS (SELECT 1 FROM requisition_comment WHERE id=? AND requisition_id=?)
+`, c.RequisitionId, parentId, c.CharacterId, c.CharacterName, c.Body, parentId, parentId, c.RequisitionId)
+	if err != nil {
+		return fmt.Errorf("error inserting comment: %w", err)
+	}
+	if err = expectAffected(res, errInvalidParentComment); err != nil {
+		return err
+	}
+
+	c.Id, err = res.LastInsertId()
+	return err
+}
+
+func (dao *dao) listRequisitionComments(reqId int64) ([]requisitionComment, error) {
+	rows, err := dao.db.Query(`
+SELECT id, requisition_id, parent_id, character_id, character_name, body, created_at
+FROM requisition_comment
+WHERE requisition_id=?
+ORDER BY created_at ASC, id ASC
+`, reqId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []requisitionComment{}
+	for rows.Next() {
+		var c requisitionComment
+		var parentId sql.NullInt64
+		if err = rows.Scan(&c.Id, &c.RequisitionId, &parentId, &c.CharacterId, &c.CharacterName, &c.Body, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		c.ParentId = parentId.Int64
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return comments, nil
+}
+
+// expectAffected returns errNone if res didn't modify any rows
+func expectAffected(res sql.Result, errNone error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNone
+	}
+	return nil
+}
+
+// completeRequisitionByContract closes an open requisition delivered by a corporation contract.
+// Unlike completeRequisition, any lock on the requisition is ignored and released.
+func (dao *dao) completeRequisitionByContract(reqId int64, actor *user, notes requisitionNotes, blueprints []requestedBlueprint, status requisitionStatus, contract requisitionContract) error {
+	bytes, err := json.Marshal(blueprints)
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %w", err)
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = insertRequisitionContract(tx, contract); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+UPDATE requisition_order
+SET
+	requisition_status=?,
+	blueprints=?,
+	private_notes=?,
+	corporation_id=?,
+	updated_at=NOW(),
+	updated_by=?
+WHERE
+	id=? AND
+	requisition_status=?
+`, status, bytes, notes.Private, contract.CorporationId, actor.CharacterName, reqId, requisitionStatus_Open)
+	if err != nil {
+		return err
+	}
+	if err = expectAffected(res, errRequisitionChanged); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM requisition_lock WHERE requisition_id=?`, reqId); err != nil {
+		return fmt.Errorf("error releasing lock: %w", err)
+	}
+
+	if err = insertRequisitionEvent(tx, newRequisitionEvent(reqId, requisitionAction_Complete, actor, requisitionStatus_Open, status, notes)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// flagContractMismatch records a contract which doesn't match the requisition it was delivered against
+func (dao *dao) flagContractMismatch(actor *user, contract requisitionContract) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = insertRequisitionContract(tx, contract); err != nil {
+		return err
+	}
+
+	notes := requisitionNotes{Private: fmt.Sprintf("contract %d: %s", contract.ContractId, contract.Notes)}
+	ev := newRequisitionEvent(contract.RequisitionId, requisitionAction_Mismatch, actor, requisitionStatus_Open, requisitionStatus_Open, notes)
+	if err = insertRequisitionEvent(tx, ev); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertRequisitionContract marks a contract as processed.
+// Returns errRequisitionChanged if another replica already processed it.
+func insertRequisitionContract(ex execer, c requisitionContract) error {
+	var reqId sql.NullInt64
+	if c.RequisitionId > 0 {
+		reqId = sql.NullInt64{Int64: c.RequisitionId, Valid: true}
+	}
+
+	res, err := ex.Exec(`
+INSERT IGNORE INTO requisition_contract
+(contract_id, requisition_id, match_result, corporation_id, issuer_id, assignee_id, contract_status, notes)
+VALUES (?,?,?,?,?,?,?,?)
+`, c.ContractId, reqId, c.Match, c.CorporationId, c.IssuerId, c.AssigneeId, c.ContractStatus, c.Notes)
+	if err != nil {
+		return fmt.Errorf("error inserting requisition contract: %w", err)
+	}
+	return expectAffected(res, errRequisitionChanged)
+}
+
+// listProcessedContracts returns the subset of contractIds which have already been matched
+func (dao *dao) listProcessedContracts(contractIds []int32) (map[int32]bool, error) {
+	processed := map[int32]bool{}
+	if len(contractIds) == 0 {
+		return processed, nil
+	}
+
+	ids := make([]any, len(contractIds))
+	for i, id := range contractIds {
+		ids[i] = id
+	}
+
+	params := sqlparams.New()
+	rows, err := dao.db.Query(`
+SELECT contract_id
+FROM requisition_contract
+WHERE contract_id IN (`+params.AddParams(ids...)+`)
+`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int32
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		processed[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return processed, nil
+}
+
+// listRequisitionContracts returns matched contracts, most recent first. match filters by result if set.
+func (dao *dao) listRequisitionContracts(match string) ([]requisitionContract, error) {
+	params := sqlparams.New()
+	filter := "1=1"
+	if match != "" {
+		filter = "match_result=" + params.AddParam(match)
+	}
+
+	rows, err := dao.db.Query(`
+SELECT contract_id, requisition_id, match_result, corporation_id, issuer_id, assignee_id, contract_status, notes, processed_at
+FROM requisition_contract
+WHERE `+filter+`
+ORDER BY processed_at DESC, contract_id DESC
+`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contracts := []requisitionContract{}
+	for rows.Next() {
+		var c requisitionContract
+		var reqId sql.NullInt64
+		var notes sql.NullString
+		if err = rows.Scan(&c.ContractId, &reqId, &c.Match, &c.CorporationId, &c.IssuerId, &c.AssigneeId, &c.ContractStatus, &notes, &c.ProcessedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		c.RequisitionId = reqId.Int64
+		c.Notes = notes.String
+		contracts = append(contracts, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return contracts, nil
+}
+
+// upsertIndustryJobs inserts new jobs and updates the status of known jobs
+func (dao *dao) upsertIndustryJobs(jobs []industryJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for chunk := range slices.Chunk(jobs, 500) {
+		params := sqlparams.New()
+		values := make([]string, len(chunk))
+		for i, job := range chunk {
+			var completed sql.NullTime
+			if !job.CompletedDate.IsZero() {
+				completed = sql.NullTime{Time: job.CompletedDate, Valid: true}
+			}
+			values[i] = "(" + params.AddParams(job.JobId, job.CorporationId, job.ActivityId, job.Status, job.BlueprintId, job.BlueprintTypeId,
+				job.BlueprintLocationId, job.FacilityId, job.InstallerId, job.ProductTypeId, job.Runs, job.LicensedRuns,
+				job.StartDate, job.EndDate, completed) + ")"
+		}
+
+		if _, err = tx.Exec(`
+INSERT INTO industry_job
+(job_id, corporation_id, activity_id, job_status, blueprint_id, blueprint_type_id, blueprint_location_id, facility_id, installer_id,
+	product_type_id, runs, licensed_runs, start_date, end_date, completed_date)
+VALUES `+strings.Join(values, ",")+`
+ON DUPLICATE KEY UPDATE
+	corporation_id=VALUES(corporation_id),
+	job_status=VALUES(job_status),
+	end_date=VALUES(end_date),
+	completed_date=VALUES(completed_date),
+	updated_at=NOW()
+`, params...); err != nil {
+			return fmt.Errorf("error upserting industry jobs: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// listActiveIndustryJobs returns unfinished jobs for the activities, ending soonest first.
+// corporationId filters by the corp running the job if set.
+func (dao *dao) listActiveIndustryJobs(corporationId int32, activities []int32) ([]industryJob, error) {
+	ids := make([]any, len(activities))
+	for i, id := range activities {
+		ids[i] = id
+	}
+
+	params := sqlparams.New()
+	filter := "activity_id IN (" + params.AddParams(ids...) + ")"
+	if corporationId > 0 {
+		filter += " AND corporation_id = " + params.AddParam(corporationId)
+	}
+
+	rows, err := dao.db.Query(`
+SELECT job_id, corporation_id, activity_id, job_status, blueprint_id, blueprint_type_id, blueprint_location_id, facility_id, installer_id,
+	product_type_id, runs, licensed_runs, start_date, end_date
+FROM industry_job
+WHERE
+	job_status IN ('active', 'paused', 'ready') AND
+	`+filter+`
+ORDER BY end_date ASC, job_id ASC
+`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []industryJob{}
+	for rows.Next() {
+		var job industryJob
+		if err = rows.Scan(&job.JobId, &job.CorporationId, &job.ActivityId, &job.Status, &job.BlueprintId, &job.BlueprintTypeId, &job.BlueprintLocationId,
+			&job.FacilityId, &job.InstallerId, &job.ProductTypeId, &job.Runs, &job.LicensedRuns, &job.StartDate, &job.EndDate); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		job.Activity = industryActivity_name[job.ActivityId]
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return jobs, nil
+}
+
+func (dao *dao) listStockTargets() ([]stockTarget, error) {
+	rows, err := dao.db.Query(`
+SELECT id, type_id, min_runs, min_me, min_te, target, updated_at, updated_by
+FROM stock_target
+ORDER BY type_id ASC, min_runs ASC, min_me ASC, min_te ASC
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []stockTarget{}
+	for rows.Next() {
+		var t stockTarget
+		if err = rows.Scan(&t.Id, &t.TypeId, &t.MinRuns, &t.MinMaterialEfficiency, &t.MinTimeEfficiency, &t.Target, &t.UpdatedAt, &t.UpdatedBy); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return targets, nil
+}
+
+// upsertStockTarget sets the target for a type and quality bracket, returning its id
+func (dao *dao) upsertStockTarget(t stockTarget) (int64, error) {
+	res, err := dao.db.Exec(`
+INSERT INTO stock_target
+(type_id, min_runs, min_me, min_te, target, updated_by)
+VALUES (?,?,?,?,?,?)
+ON DUPLICATE KEY UPDATE
+	id=LAST_INSERT_ID(id),
+	target=VALUES(target),
+	updated_at=NOW(),
+	updated_by=VALUES(updated_by)
+`, t.TypeId, t.MinRuns, t.MinMaterialEfficiency, t.MinTimeEfficiency, t.Target, t.UpdatedBy)
+	if err != nil {
+		return 0, fmt.Errorf("error upserting stock target: %w", err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (dao *dao) deleteStockTarget(id int64) error {
+	res, err := dao.db.Exec(`DELETE FROM stock_target WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, sql.ErrNoRows)
+}
+
+// saveInventorySnapshot stores s, keeping only the latest few snapshots
+func (dao *dao) saveInventorySnapshot(s *inventorySnapshot) error {
+	data, err := encodeInventorySnapshot(s)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO inventory_snapshot (updated_at, data) VALUES (?,?)`, s.UpdatedAt, data)
+	if err != nil {
+		return fmt.Errorf("error inserting inventory snapshot: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM inventory_snapshot WHERE id <= ?`, id-inventorySnapshotsKept); err != nil {
+		return fmt.Errorf("error pruning inventory snapshots: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// loadInventorySnapshot returns the most recent snapshot, or sql.ErrNoRows if there are none
+func (dao *dao) loadInventorySnapshot() (*inventorySnapshot, error) {
+	var data []byte
+	if err := dao.db.QueryRow(`
+SELECT data
+FROM inventory_snapshot
+ORDER BY id DESC
+LIMIT 1
+`).Scan(&data); err != nil {
+		return nil, err
+	}
+	return decodeInventorySnapshot(data)
+}
+
+// insertInventoryChanges records the differences found by a sync
+func (dao *dao) insertInventoryChanges(changes []inventoryChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for chunk := range slices.Chunk(changes, 500) {
+		params := sqlparams.New()
+		values := make([]string, len(chunk))
+		for i, c := range chunk {
+			var fromLocation, fromContainer sql.NullInt64
+			var fromDivision sql.NullInt16
+			if c.From != nil {
+				fromLocation = sql.NullInt64{Int64: c.From.LocationId, Valid: true}
+				fromDivision = sql.NullInt16{Int16: int16(c.From.Division), Valid: true}
+				fromContainer = sql.NullInt64{Int64: c.From.ContainerId, Valid: true}
+			}
+			values[i] = "(" + params.AddParams(c.SyncedAt, c.Kind, c.TypeId, c.TypeName, c.Copy,
+				c.Runs, c.MaterialEfficiency, c.TimeEfficiency,
+				c.Location.CorporationId, c.Location.LocationId, c.Location.Division, c.Location.ContainerId,
+				fromLocation, fromDivision, fromContainer, c.QuantityBefore, c.QuantityAfter) + ")"
+		}
+
+		if _, err = tx.Exec(`
+INSERT INTO inventory_change
+(synced_at, kind, type_id, type_name, is_copy, runs, me, te, corporation_id, location_id, division, container_id,
+	from_location_id, from_division, from_container_id, quantity_before, quantity_after)
+VALUES `+strings.Join(values, ",")+`
+`, params...); err != nil {
+			return fmt.Errorf("error inserting inventory changes: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryInventoryChanges returns a page of changes matching filter, newest first, and the cursor for the next page
+// which is empty on the last page
+func (dao *dao) queryInventoryChanges(filter *inventoryChangeFilter) ([]inventoryChange, string, error) {
+	params := sqlparams.New()
+	where := []string{"1=1"}
+
+	if len(filter.Kinds) > 0 {
+		kinds := make([]string, len(filter.Kinds))
+		for i, kind := range filter.Kinds {
+			kinds[i] = string(kind)
+		}
+		where = append(where, "kind IN ("+params.AddParams(kinds)+")")
+	}
+	if filter.TypeId > 0 {
+		where = append(where, "type_id="+params.AddParam(filter.TypeId))
+	}
+	if filter.CorporationId > 0 {
+		where = append(where, "corporation_id="+params.AddParam(filter.CorporationId))
+	}
+	if filter.LocationId > 0 {
+		where = append(where, "(location_id="+params.AddParam(filter.LocationId)+" OR from_location_id="+params.AddParam(filter.LocationId)+")")
+	}
+	if filter.Copy != nil {
+		where = append(where, "is_copy="+params.AddParam(*filter.Copy))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "synced_at >= "+params.AddParam(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "synced_at < "+params.AddParam(filter.To))
+	}
+	if filter.Cursor > 0 {
+		where = append(where, "id < "+params.AddParam(filter.Cursor))
+	}
+
+	rows, err := dao.db.Query(`
+SELECT id, synced_at, kind, type_id, type_name, is_copy, runs, me, te, corporation_id, location_id, division, container_id,
+	from_location_id, from_division, from_container_id, quantity_before, quantity_after
+FROM inventory_change
+WHERE `+strings.Join(where, " AND ")+`
+ORDER BY id DESC
+LIMIT `+params.AddParam(filter.Limit+1), params...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	changes := []inventoryChange{}
+	for rows.Next() {
+		var c inventoryChange
+		var fromLocation, fromContainer sql.NullInt64
+		var fromDivision sql.NullInt16
+		if err = rows.Scan(&c.Id, &c.SyncedAt, &c.Kind, &c.TypeId, &c.TypeName, &c.Copy, &c.Runs, &c.MaterialEfficiency, &c.TimeEfficiency,
+			&c.Location.CorporationId, &c.Location.LocationId, &c.Location.Division, &c.Location.ContainerId,
+			&fromLocation, &fromDivision, &fromContainer, &c.QuantityBefore, &c.QuantityAfter); err != nil {
+			return nil, "", fmt.Errorf("error scanning row: %w", err)
+		}
+		if fromLocation.Valid {
+			c.From = &itemLocation{
+				CorporationId: c.Location.CorporationId,
+				LocationId:    fromLocation.Int64,
+				Division:      int(fromDivision.Int16),
+				ContainerId:   fromContainer.Int64,
+			}
+		}
+		changes = append(changes, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	if len(changes) <= filter.Limit {
+		return changes, "", nil
+	}
+
+	changes = changes[:filter.Limit]
+	return changes, strconv.FormatInt(changes[len(changes)-1].Id, 10), nil
+}
+
+// getSdeVersion returns the loaded sde, or sql.ErrNoRows if none has been imported
+func (dao *dao) getSdeVersion() (*sdeVersion, error) {
+	var v sdeVersion
+	var releaseDate sql.NullTime
+	if err := dao.db.QueryRow(`
+SELECT build_number, importer_version, release_date, imported_at
+FROM sde_version
+`).Scan(&v.BuildNumber, &v.ImporterVersion, &releaseDate, &v.ImportedAt); err != nil {
+		return nil, err
+	}
+	v.ReleaseDate = releaseDate.Time
+	return &v, nil
+}
+
+// getSdeTypeNames returns the names of the types in typeIds which are in the sde
+func (dao *dao) getSdeTypeNames(typeIds []int32) (map[int32]string, error) {
+	names := make(map[int32]string, len(typeIds))
+	for chunk := range slices.Chunk(typeIds, 1000) {
+		ids := make([]any, len(chunk))
+		for i, id := range chunk {
+			ids[i] = id
+		}
+		params := sqlparams.New()
+		rows, err := dao.db.Query(`
+SELECT type_id, name
+FROM sde_type
+WHERE type_id IN (`+params.AddParams(ids...)+`)
+`, params...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var id int32
+			var name string
+			if err = rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning row: %w", err)
+			}
+			names[id] = name
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error iterating rows: %w", err)
+		}
+	}
+	return names, nil
+}
+
+// getSdeBlueprintsByName finds the blueprints in the sde with any of names, returning their names by type id
+func (dao *dao) getSdeBlueprintsByName(names []string) (map[int32]string, error) {
+	found := make(map[int32]string, len(names))
+	for chunk := range slices.Chunk(names, 1000) {
+		params := sqlparams.New()
+		rows, err := dao.db.Query(`
+SELECT t.type_id, t.name
+FROM sde_type t
+JOIN sde_blueprint b ON b.blueprint_type_id = t.type_id
+WHERE t.name IN (`+params.AddParams(chunk)+`)
+`, params...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var id int32
+			var name string
+			if err = rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning row: %w", err)
+			}
+			found[id] = name
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error iterating rows: %w", err)
+		}
+	}
+	return found, nil
+}
+
+// getSdeMaxRuns returns the most runs a copy of each blueprint type can have, for the types in the sde
+func (dao *dao) getSdeMaxRuns() (map[int32]int16, error) {
+	rows, err := dao.db.Query(`
+SELECT blueprint_type_id, max_production_limit
+FROM sde_blueprint
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	maxRuns := map[int32]int16{}
+	for rows.Next() {
+		var typeId int32
+		var runs int16
+		if err = rows.Scan(&typeId, &runs); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		maxRuns[typeId] = runs
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return maxRuns, nil
+}
+
+// insertRows inserts rows into table in batches
+func insertRows(ex execer, table string, columns string, rows [][]any) error {
+	for chunk := range slices.Chunk(rows, 1000) {
+		params := sqlparams.New()
+		values := make([]string, len(chunk))
+		for i, row := range chunk {
+			values[i] = "(" + params.AddParams(row...) + ")"
+		}
+		if _, err := ex.Exec(`INSERT INTO `+table+` (`+columns+`) VALUES `+strings.Join(values, ","), params...); err != nil {
+			return fmt.Errorf("error inserting into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// replaceSde swaps the contents of the sde tables for data
+func (dao *dao) replaceSde(data *sdeData) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"sde_version", "sde_category", "sde_group", "sde_market_group", "sde_type", "sde_blueprint", "sde_blueprint_activity", "sde_blueprint_material"} {
+		if _, err = tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("error clearing %s: %w", table, err)
+		}
+	}
+
+	var rows [][]any
+	for _, c := range data.Categories {
+		rows = append(rows, []any{c.Key, c.Name.String(), c.Published})
+	}
+	if err = insertRows(tx, "sde_category", "category_id, name, published", rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, g := range data.Groups {
+		rows = append(rows, []any{g.Key, g.CategoryId, g.Name.String(), g.Published})
+	}
+	if err = insertRows(tx, "sde_group", "group_id, category_id, name, published", rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, g := range data.MarketGroups {
+		rows = append(rows, []any{g.Key, g.ParentGroupId, g.Name.String(), g.HasTypes})
+	}
+	if err = insertRows(tx, "sde_market_group", "market_group_id, parent_group_id, name, has_types", rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, t := range data.Types {
+		rows = append(rows, []any{t.Key, t.GroupId, t.MarketGroupId, t.Name.String(), t.Published})
+	}
+	if err = insertRows(tx, "sde_type", "type_id, group_id, market_group_id, name, published", rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	for _, bp := range data.Blueprints {
+		rows = append(rows, []any{bp.Key, bp.MaxProductionLimit})
+	}
+	if err = insertRows(tx, "sde_blueprint", "blueprint_type_id, max_production_limit", rows); err != nil {
+		return err
+	}
+
+	rows = rows[:0]
+	var materials [][]any
+	for _, bp := range data.Blueprints {
+		for activity, a := range bp.Activities {
+			rows = append(rows, []any{bp.Key, activity, a.Time})
+			for _, m := range a.Materials {
+				materials = append(materials, []any{bp.Key, activity, m.TypeId, m.Quantity, false})
+			}
+			for _, p := range a.Products {
+				materials = append(materials, []any{bp.Key, activity, p.TypeId, p.Quantity, true})
+			}
+		}
+	}
+	if err = insertRows(tx, "sde_blueprint_activity", "blueprint_type_id, activity, time", rows); err != nil {
+		return err
+	}
+	if err = insertRows(tx, "sde_blueprint_material", "blueprint_type_id, activity, type_id, quantity, is_product", materials); err != nil {
+		return err
+	}
+
+	var releaseDate sql.NullTime
+	if !data.Info.ReleaseDate.IsZero() {
+		releaseDate = sql.NullTime{Time: data.Info.ReleaseDate, Valid: true}
+	}
+	if _, err = tx.Exec(`INSERT INTO sde_version (build_number, importer_version, release_date) VALUES (?,?,?)`, data.Info.BuildNumber, sdeImporterVersion, releaseDate); err != nil {
+		return fmt.Errorf("error inserting sde version: %w", err)
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/goesi"
+)
+
+const (
+	headerCacheStatus = "X-Cache-Status"
+	headerEtag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+	headerCacheReused = "X-Cache-Reused" // the body was left empty, the caller already holds it decoded
+
+	cacheStatus_Hit         = "HIT"         // served from the cache without a request
+	cacheStatus_Revalidated = "REVALIDATED" // esi answered 304, the cached body is still current
+	cacheStatus_Miss        = "MISS"
+
+	minRefreshInterval = time.Minute
+	maxRefreshInterval = time.Hour
+	cacheIdleEviction  = 6 * time.Hour // expired entries which haven't been asked for in this long are dropped
+)
+
+// esiDecodedPage is the decoded body of a cached response with etag
+type esiDecodedPage struct {
+	etag  string
+	items any
+}
+
+// decodedPages holds the decoded pages fetched by esiPages by url, so an unchanged page isn't decoded again
+var decodedPages = newSyncMap[string, esiDecodedPage]()
+
+type reuseDecodedKey struct{}
+
+// withDecodedPages marks requests made with ctx as able to reuse the pages in decodedPages
+func withDecodedPages(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reuseDecodedKey{}, true)
+}
+
+// esiCacheEntry is a stored 200 response
+type esiCacheEntry struct {
+	header     http.Header
+	body       []byte
+	etag       string
+	expires    time.Time
+	fetchedAt  time.Time // last time esi was asked for this url
+	modifiedAt time.Time // last time the body changed
+}
+
+func (e *esiCacheEntry) response(req *http.Request, status string) *http.Response {
+	header := e.header.Clone()
+	header.Set(headerCacheStatus, status)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// esiCache is a http.RoundTripper which stores GET responses until they expire, then revalidates them with their ETag.
+// Every response it returns has an X-Cache-Status header.
+type esiCache struct {
+	next    http.RoundTripper
+	entries *syncMap[string, *esiCacheEntry]
+}
+
+func newEsiCache(next http.RoundTripper) *esiCache {
+	return &esiCache{
+		next:    next,
+		entries: newSyncMap[string, *esiCacheEntry](),
+	}
+}
+
+func (c *esiCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.next.RoundTrip(req)
+	}
+
+	resp, err := c.roundTrip(req)
+	if err == nil {
+		esiCacheResults.WithLabelValues(esiEndpoint(req.URL), resp.Header.Get(headerCacheStatus)).Inc()
+	}
+	return resp, err
+}
+
+func (c *esiCache) roundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	now := time.Now()
+	cached, ok := c.entries.Get(key)
+	if ok && now.Before(cached.expires) {
+		return c.cachedResponse(req, key, cached, cacheStatus_Hit), nil
+	}
+
+	if ok && cached.etag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(headerIfNoneMatch, cached.etag)
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		entry := *cached
+		entry.header = cached.header.Clone()
+		for _, h := range []string{"Date", "Expires", "Cache-Control", "Last-Modified"} {
+			if v := resp.Header.Get(h); v != "" {
+				entry.header.Set(h, v)
+			}
+		}
+		entry.expires = goesi.CacheExpires(resp)
+		entry.fetchedAt = now
+		c.entries.Set(key, &entry)
+		return c.cachedResponse(req, key, &entry, cacheStatus_Revalidated), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Header.Set(headerCacheStatus, cacheStatus_Miss)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &esiCacheEntry{
+		header:     resp.Header.Clone(),
+		body:       body,
+		etag:       resp.Header.Get(headerEtag),
+		expires:    goesi.CacheExpires(resp),
+		fetchedAt:  now,
+		modifiedAt: now,
+	}
+	entry.header.Set("Content-Length", strconv.Itoa(len(body)))
+	if ok && bytes.Equal(cached.body, body) {
+		entry.modifiedAt = cached.modifiedAt
+	}
+
+	// responses which can't be reused or revalidated aren't worth keeping
+	if entry.etag != "" || now.Before(entry.expires) {
+		c.entries.Set(key, entry)
+	} else {
+		c.entries.Delete(key)
+		decodedPages.Delete(key)
+	}
+
+	return entry.response(req, cacheStatus_Miss), nil
+}
+
+// cachedResponse returns the unchanged response e. If the caller already holds the body decoded its body is
+// left as an empty list and X-Cache-Reused is set.
+func (c *esiCache) cachedResponse(req *http.Request, key string, e *esiCacheEntry, status string) *http.Response {
+	resp := e.response(req, status)
+	if reuse, _ := req.Context().Value(reuseDecodedKey{}).(bool); !reuse || e.etag == "" {
+		return resp
+	}
+	if page, ok := decodedPages.Get(key); !ok || page.etag != e.etag {
+		return resp
+	}
+
+	resp.Body = io.NopCloser(strings.NewReader("[]"))
+	resp.ContentLength = 2
+	resp.Header.Set("Content-Length", "2")
+	resp.Header.Set(headerCacheReused, "true")
+	return resp
+}
+
+// nextExpiry returns when the earliest of the responses fetched since since expires, clamped to
+// between minRefreshInterval and maxRefreshInterval from now. Idle entries are evicted on the way.
+func (c *esiCache) nextExpiry(since time.Time) time.Time {
+	now := time.Now()
+	c.evict(now)
+
+	next := now.Add(maxRefreshInterval)
+	c.entries.RangeFunc(func(_ string, e *esiCacheEntry) {
+		if e.fetchedAt.Before(since) || e.expires.IsZero() {
+			return
+		}
+		if e.expires.Before(next) {
+			next = e.expires
+		}
+	})
+	if earliest := now.Add(minRefreshInterval); next.Before(earliest) {
+		return earliest
+	}
+	return next
+}
+
+// evict drops expired entries which haven't been fetched for cacheIdleEviction, such as the items of old
+// contracts, so urls which are never asked for again don't stay in memory
+func (c *esiCache) evict(now time.Time) {
+	c.entries.DeleteFunc(func(key string, e *esiCacheEntry) bool {
+		idle := now.After(e.expires) && now.Sub(e.fetchedAt) > cacheIdleEviction
+		if idle {
+			decodedPages.Delete(key)
+		}
+		return idle
+	})
+}
+
+// modifiedSince reports whether any matching response changed after since
+func (c *esiCache) modifiedSince(since time.Time, match func(u *url.URL) bool) bool {
+	var modified bool
+	c.entries.RangeFunc(func(key string, e *esiCacheEntry) {
+		if modified || !e.modifiedAt.After(since) {
+			return
+		}
+		if u, err := url.Parse(key); err == nil && match(u) {
+			modified = true
+		}
+	})
+	return modified
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	headerErrorLimitRemain = "X-ESI-Error-Limit-Remain"
+	headerErrorLimitReset  = "X-ESI-Error-Limit-Reset"
+
+	esiMaxAttempts      = 5
+	esiErrorBudgetFloor = 10 // requests are held back once this few errors remain in the window
+	esiPageConcurrency  = 8
+	esiBackoffBase      = 250 * time.Millisecond
+
+	statusErrorLimited = 420 // esi's error limit has been reached
+)
+
+var (
+	errRetriesExceeded = errors.New("retries exceeded")
+	esiIdSegment       = regexp.MustCompile(`/\d+(/|$)`)
+)
+
+// esiEndpoint turns a request path into a metric label, replacing ids with {id}
+func esiEndpoint(u *url.URL) string {
+	path := u.Path
+	for esiIdSegment.MatchString(path) {
+		path = esiIdSegment.ReplaceAllString(path, "/{id}$1")
+	}
+	return path
+}
+
+// esiErrorBudget tracks how many errors esi will accept before it starts answering 420.
+// The budget is shared by every request the app makes.
+type esiErrorBudget struct {
+	mu      sync.Mutex
+	remain  int
+	resetAt time.Time
+}
+
+// wait blocks until the error budget has room for another request
+func (b *esiErrorBudget) wait(ctx context.Context) error {
+	b.mu.Lock()
+	var delay time.Duration
+	if b.remain < esiErrorBudgetFloor {
+		delay = time.Until(b.resetAt)
+	}
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *esiErrorBudget) update(resp *http.Response) {
+	remain, err := strconv.Atoi(resp.Header.Get(headerErrorLimitRemain))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.Atoi(resp.Header.Get(headerErrorLimitReset))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode == statusErrorLimited {
+		remain = 0
+	}
+
+	b.mu.Lock()
+	b.remain = remain
+	b.resetAt = time.Now().Add(time.Duration(reset) * time.Second)
+	b.mu.Unlock()
+
+	esiErrorLimitRemain.Set(float64(remain))
+}
+
+// esiTransport sends requests to esi once the error budget allows and records metrics for each endpoint.
+// It sits below the esiCache so that cache hits don't wait on the budget.
+type esiTransport struct {
+	next   http.RoundTripper
+	budget esiErrorBudget
+}
+
+func newEsiTransport(next http.RoundTripper) *esiTransport {
+	return &esiTransport{next: next, budget: esiErrorBudget{remain: esiErrorBudgetFloor}}
+}
+
+func (t *esiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.budget.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	endpoint := esiEndpoint(req.URL)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		esiRequestDuration.WithLabelValues(endpoint, "error").Observe(time.Since(start).Seconds())
+		return nil, err
+	}
+
+	esiRequestDuration.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	t.budget.update(resp)
+	return resp, nil
+}
+
+// retryableEsiError reports whether a failed request may succeed if it is sent again
+func retryableEsiError(resp *http.Response, err error) bool {
+	if resp == nil {
+		return !errors.Is(err, context.Canceled) // timeouts and connection errors
+	}
+	switch {
+	case resp.StatusCode == statusErrorLimited:
+		return true // held back by the error budget until the window resets
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return true
+	}
+	return false
+}
+
+// esiCall makes a single esi request, retrying errors which may go away with backoff.
+// call is given a context with esiRequestTimeout.
+func esiCall[T any](ctx context.Context, logger *zap.Logger, call func(ctx context.Context) (T, *http.Response, error)) (T, *http.Response, error) {
+	var (
+		out  T
+		resp *http.Response
+		err  error
+	)
+
+	for attempt := 1; attempt <= esiMaxAttempts; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, esiRequestTimeout)
+		out, resp, err = call(reqCtx)
+		cancel()
+
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return out, resp, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		status := "error"
+		if resp != nil {
+			status = resp.Status
+		}
+		if !retryableEsiError(resp, err) {
+			if body := parseEsiError(err); body != "" {
+				err = fmt.Errorf("%w: %s", err, body)
+			}
+			return out, resp, err
+		}
+
+		logger.Warn("esi request failed", zap.Int("attempt", attempt), zap.String("status", status), zap.String("body", parseEsiError(err)), zap.Error(err))
+
+		backoff := esiBackoffBase<<attempt + time.Duration(rand.Int64N(int64(esiBackoffBase)))
+		select {
+		case <-ctx.Done():
+			return out, resp, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	return out, resp, fmt.Errorf("%w: %w", errRetriesExceeded, err)
+}
+
+// decodedPage fetches a page, reusing the items decoded last time if the cached response hasn't changed
+func decodedPage[T any](ctx context.Context, page int32, fetch func(ctx context.Context, page int32) ([]T, *http.Response, error)) ([]T, *http.Response, error) {
+	items, resp, err := fetch(withDecodedPages(ctx), page)
+	if err != nil || resp == nil || resp.StatusCode != http.StatusOK || resp.Request == nil {
+		return items, resp, err
+	}
+
+	key := resp.Request.URL.String()
+	if resp.Header.Get(headerCacheReused) != "" {
+		// callers may modify what they're given, the stored page stays as decoded
+		if held, ok := decodedPages.Get(key); ok {
+			if items, ok := held.items.([]T); ok {
+				return slices.Clone(items), resp, nil
+			}
+		}
+		return nil, resp, fmt.Errorf("decoded page %s is no longer held", key)
+	}
+
+	if etag := resp.Header.Get(headerEtag); etag != "" {
+		decodedPages.Set(key, esiDecodedPage{etag: etag, items: slices.Clone(items)})
+	}
+	return items, resp, nil
+}
+
+// esiPages fetches every page of a paginated endpoint. The first page gives the page count from X-Pages,
+// the rest are fetched concurrently. Items are returned in page order, pages which haven't changed since
+// they were last fetched aren't decoded again.
+func esiPages[T any](ctx context.Context, logger *zap.Logger, fetch func(ctx context.Context, page int32) ([]T, *http.Response, error)) ([]T, error) {
+	first, resp, err := esiCall(ctx, logger, func(ctx context.Context) ([]T, *http.Response, error) {
+		return decodedPage(ctx, 1, fetch)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("page 1: %w", err)
+	}
+
+	pages, _ := strconv.Atoi(resp.Header.Get(headerPages))
+	if pages <= 1 {
+		return first, nil
+	}
+
+	var (
+		results = make([][]T, pages)
+		errs    = make([]error, pages)
+		sem     = make(chan struct{}, esiPageConcurrency)
+		wg      sync.WaitGroup
+	)
+	results[0] = first
+
+	for i := 1; i < pages; i++ {
+		page := int32(i + 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			items, _, err := esiCall(ctx, logger.With(zap.Int32("page", page)), func(ctx context.Context) ([]T, *http.Response, error) {
+				return decodedPage(ctx, page, fetch)
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("page %d: %w", page, err)
+				return
+			}
+			results[i] = items
+		}()
+	}
+	wg.Wait()
+
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var n int
+	for _, items := range results {
+		n += len(items)
+	}
+	out := make([]T, 0, n)
+	for _, items := range results {
+		out = append(out, items...)
+	}
+	return out, nil
+}
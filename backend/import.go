@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+const (
+	maxImportBytes = 64 << 10
+	maxImportLines = 500
+)
+
+var (
+	importSuffixQty = regexp.MustCompile(`^(.+?)\s+x\s?([\d,.]+)$`)  // multibuy and EFT "Name x5"
+	importPrefixQty = regexp.MustCompile(`^([\d,.]+)\s?x\s+(.+)$`)   // "5x Name"
+	importTrailQty  = regexp.MustCompile(`^(.+?)\s+([\d,.]+)$`)      // multibuy "Name 5"
+	importQty       = regexp.MustCompile(`^(\d+|\d{1,3}(,\d{3})+)$`) // 5, 1000 or 1,000
+)
+
+type importLine struct {
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+type importResponse struct {
+	Draft      postRequisitionOrderRequest `json:"draft"`
+	Unresolved []importLine                `json:"unresolved"`
+}
+
+// parseClipboardLine extracts an item name and quantity from a line of an EVE inventory copy,
+// multibuy or EFT list. ok is false for lines which don't name an item, err is set when the quantity isn't
+// a whole number.
+func parseClipboardLine(line string) (name string, qty int32, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", 0, false, nil
+	}
+
+	// EFT header "[Drake, fit name]" or empty slot "[Empty High slot]"
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		inner := strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+		if strings.HasPrefix(inner, "Empty ") {
+			return "", 0, false, nil
+		}
+		name, _, _ = strings.Cut(inner, ",")
+		return strings.TrimSpace(name), 1, true, nil
+	}
+
+	// inventory copy "Name\tQuantity\tGroup...", the quantity is blank for a single item
+	if strings.Contains(line, "\t") {
+		fields := strings.Split(line, "\t")
+		name = strings.TrimSpace(fields[0])
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			return name, 1, true, nil
+		}
+		if qty, err = parseImportQty(fields[1]); err != nil {
+			return name, 0, true, fmt.Errorf("invalid quantity %q: %w", strings.TrimSpace(fields[1]), err)
+		}
+		return name, qty, true, nil
+	}
+
+	// EFT module with a loaded charge "Module, Charge"
+	if before, _, found := strings.Cut(line, ", "); found {
+		line = before
+	}
+
+	var qtyErr error
+	for _, re := range []*regexp.Regexp{importSuffixQty, importTrailQty, importPrefixQty} {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name, s := m[1], m[2]
+		if re == importPrefixQty {
+			name, s = m[2], m[1]
+		}
+		if n, err := parseImportQty(s); err == nil {
+			return name, n, true, nil
+		} else if qtyErr == nil {
+			qtyErr = fmt.Errorf("invalid quantity %q: %w", s, err)
+		}
+	}
+	if qtyErr != nil {
+		return line, 0, true, qtyErr
+	}
+
+	return line, 1, true, nil
+}
+
+// parseImportQty parses a positive whole quantity, optionally with thousands separators, eg. 1,000.
+// Decimals such as 1.5 are rejected rather than read as 15.
+func parseImportQty(s string) (int32, error) {
+	s = strings.TrimSpace(s)
+	if !importQty.MatchString(s) {
+		return 0, errors.New("quantity must be a whole number")
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(s, ",", ""), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("quantity must be at least 1")
+	}
+	return int32(n), nil
+}
+
+// addImportQty sums the quantities of repeated lines, saturating rather than overflowing.
+// A positive limit caps the result, no single line may ask for more blueprints than a requisition allows.
+func addImportQty(a, b, limit int32) int32 {
+	sum := min(int64(a)+int64(b), math.MaxInt32)
+	if limit > 0 {
+		sum = min(sum, int64(limit))
+	}
+	return int32(sum)
+}
+
+// importRequisition parses pasted EVE clipboard text into a draft requisition.
+// Names are resolved against the blueprints in the inventory and the sde, with or without the " Blueprint" suffix.
+// Stock isn't checked until the draft is submitted. Lines are requested at any quality, the draft isn't saved.
+func (app *app) importRequisition(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		httpError(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	type parsedLine struct {
+		line int
+		text string
+		name string
+		qty  int32
+	}
+	var parsed []parsedLine
+	resp := importResponse{Unresolved: []importLine{}}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for n := 1; scanner.Scan(); n++ {
+		if n > maxImportLines {
+			httpError(w, "too many lines, the limit is "+strconv.Itoa(maxImportLines), http.StatusBadRequest)
+			return
+		}
+
+		name, qty, ok, err := parseClipboardLine(scanner.Text())
+		if !ok {
+			continue
+		} else if err != nil {
+			resp.Unresolved = append(resp.Unresolved, importLine{Line: n, Text: scanner.Text(), Reason: err.Error()})
+			continue
+		}
+		parsed = append(parsed, parsedLine{line: n, text: scanner.Text(), name: name, qty: qty})
+	}
+	if err = scanner.Err(); err != nil {
+		httpError(w, "error reading lines: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// blueprints held in any quantity, including ones which are out of stock
+	app.invStateLock.RLock()
+	byName := make(map[string]int32, len(app.inventoryState.bpcs)+len(app.inventoryState.bpos))
+	names := map[int32]string{}
+	for _, held := range []map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok{app.inventoryState.bpcs, app.inventoryState.bpos} {
+		for typeId := range held {
+			names[typeId] = app.inventoryState.typeNames[typeId]
+			byName[strings.ToLower(names[typeId])] = typeId
+		}
+	}
+	app.invStateLock.RUnlock()
+
+	var missing []string
+	for _, p := range parsed {
+		key := strings.ToLower(p.name)
+		if _, ok := byName[key]; !ok {
+			if _, ok = byName[key+" blueprint"]; !ok {
+				missing = append(missing, p.name, p.name+" Blueprint")
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sde, err := app.dao.getSdeBlueprintsByName(missing)
+		if err != nil {
+			// the inventory names still resolve most lines
+			logger.Error("error resolving blueprint names from the sde", zap.Error(err))
+		}
+		for typeId, name := range sde {
+			names[typeId] = name
+			byName[strings.ToLower(name)] = typeId
+		}
+	}
+
+	lines := map[int32]int{} // index of each type in the draft
+	for _, p := range parsed {
+		key := strings.ToLower(p.name)
+		typeId, found := byName[key]
+		if !found {
+			typeId, found = byName[key+" blueprint"]
+		}
+		if !found {
+			resp.Unresolved = append(resp.Unresolved, importLine{Line: p.line, Text: p.text, Reason: "no blueprint named " + p.name})
+			continue
+		}
+
+		if i, ok := lines[typeId]; ok {
+			resp.Draft.Blueprints[i].Quantity = addImportQty(resp.Draft.Blueprints[i].Quantity, p.qty, app.config.MaxBlueprints)
+			continue
+		}
+		lines[typeId] = len(resp.Draft.Blueprints)
+		resp.Draft.Blueprints = append(resp.Draft.Blueprints, requestedBlueprint{
+			TypeId:   typeId,
+			Name:     names[typeId],
+			Quantity: addImportQty(0, p.qty, app.config.MaxBlueprints),
+			Any:      true,
+		})
+	}
+
+	logger.Debug("imported requisition", zap.Int("lines", len(resp.Draft.Blueprints)), zap.Int("unresolved", len(resp.Unresolved)))
+	httpWrite(w, resp)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseClipboardLine(t *testing.T) {
+	tests := []struct {
+		format string
+		line   string
+		name   string
+		qty    int32
+		ok     bool
+		err    bool
+	}{
+		{"inventory", "Raven Blueprint\t3\tBattleship Blueprint\t\t\t0.01 m3", "Raven Blueprint", 3, true, false},
+		{"inventory", "Raven Blueprint\t1,000\tBattleship Blueprint", "Raven Blueprint", 1000, true, false},
+		{"inventory", "Raven Blueprint\t\tBattleship Blueprint", "Raven Blueprint", 1, true, false},
+		{"inventory", "Raven Blueprint\t1.5\tBattleship Blueprint", "Raven Blueprint", 0, true, true},
+		{"inventory", "Raven Blueprint\t9,999,999,999\tBattleship Blueprint", "Raven Blueprint", 0, true, true},
+
+		{"multibuy", "Raven 5", "Raven", 5, true, false},
+		{"multibuy", "Raven x5", "Raven", 5, true, false},
+		{"multibuy", "Raven x 5", "Raven", 5, true, false},
+		{"multibuy", "5x Raven", "Raven", 5, true, false},
+		{"multibuy", "Raven 1,000", "Raven", 1000, true, false},
+		{"multibuy", "Raven 1.000", "Raven", 0, true, true},
+		{"multibuy", "Raven 1,00", "Raven", 0, true, true},
+		{"multibuy", "Raven 0", "Raven", 0, true, true},
+		{"multibuy", "Raven", "Raven", 1, true, false},
+		{"multibuy", "   ", "", 0, false, false},
+
+		{"eft", "[Drake, PvE fit]", "Drake", 1, true, false},
+		{"eft", "[Empty High slot]", "", 0, false, false},
+		{"eft", "Heavy Missile Launcher II, Scourge Heavy Missile", "Heavy Missile Launcher II", 1, true, false},
+		{"eft", "Hobgoblin II x5", "Hobgoblin II", 5, true, false},
+		{"eft", "Scourge Heavy Missile x2,500", "Scourge Heavy Missile", 2500, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.line, func(t *testing.T) {
+			name, qty, ok, err := parseClipboardLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if !ok || err != nil {
+				return
+			}
+			if name != tt.name || qty != tt.qty {
+				t.Errorf("got %q x%d, want %q x%d", name, qty, tt.name, tt.qty)
+			}
+		})
+	}
+}
+
+func TestAddImportQty(t *testing.T) {
+	tests := []struct {
+		a, b, limit int32
+		want        int32
+	}{
+		{1, 2, 0, 3},
+		{math.MaxInt32, 1, 0, math.MaxInt32},
+		{math.MaxInt32 - 1, math.MaxInt32, 0, math.MaxInt32},
+		{5, 10, 12, 12},
+		{0, 20, 12, 12},
+	}
+
+	for _, tt := range tests {
+		if got := addImportQty(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("addImportQty(%d, %d, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/antihax/goesi/optional"
+	"go.uber.org/zap"
+)
+
+// industry activity ids, see ramActivities in the SDE
+const (
+	industryActivity_Manufacturing    int32 = 1
+	industryActivity_ResearchTime     int32 = 3
+	industryActivity_ResearchMaterial int32 = 4
+	industryActivity_Copying          int32 = 5
+	industryActivity_Invention        int32 = 8
+	industryActivity_Reactions        int32 = 9
+)
+
+var industryActivity_name = map[int32]string{
+	industryActivity_Manufacturing:    "manufacturing",
+	industryActivity_ResearchTime:     "research_time",
+	industryActivity_ResearchMaterial: "research_material",
+	industryActivity_Copying:          "copying",
+	industryActivity_Invention:        "invention",
+	industryActivity_Reactions:        "reactions",
+}
+
+// industryJob is a corporation industry job as stored in the industry_job table
+type industryJob struct {
+	JobId               int32     `json:"job_id"`
+	CorporationId       int32     `json:"corporation_id"` // source corp running the job
+	ActivityId          int32     `json:"activity_id"`
+	Activity            string    `json:"activity"`
+	Status              string    `json:"status"`
+	BlueprintId         int64     `json:"blueprint_id"`
+	BlueprintTypeId     int32     `json:"blueprint_type_id"`
+	BlueprintName       string    `json:"blueprint_name,omitempty"`
+	BlueprintLocationId int64     `json:"blueprint_location_id"`
+	FacilityId          int64     `json:"facility_id"`
+	InstallerId         int32     `json:"installer_id"`
+	ProductTypeId       int32     `json:"product_type_id,omitempty"`
+	Runs                int32     `json:"runs"`
+	LicensedRuns        int32     `json:"licensed_runs,omitempty"`
+	StartDate           time.Time `json:"start_date"`
+	EndDate             time.Time `json:"end_date"`
+	CompletedDate       time.Time `json:"completed_date,omitzero"`
+
+	// the blueprint the job runs on, if it is still in the corp inventory
+	Blueprint *industryJobBlueprint `json:"blueprint,omitempty"`
+}
+
+type industryJobBlueprint struct {
+	ItemId             int64 `json:"item_id"`
+	MaterialEfficiency int32 `json:"material_efficiency"`
+	TimeEfficiency     int32 `json:"time_efficiency"`
+	Runs               int32 `json:"runs"` // -1 for an original
+	LocationId         int64 `json:"location_id"`
+}
+
+func industryJobFromEsi(corporationId int32, job esi.GetCorporationsCorporationIdIndustryJobs200Ok) industryJob {
+	return industryJob{
+		JobId:               job.JobId,
+		CorporationId:       corporationId,
+		ActivityId:          job.ActivityId,
+		Activity:            industryActivity_name[job.ActivityId],
+		Status:              job.Status,
+		BlueprintId:         job.BlueprintId,
+		BlueprintTypeId:     job.BlueprintTypeId,
+		BlueprintLocationId: job.BlueprintLocationId,
+		FacilityId:          job.FacilityId,
+		InstallerId:         job.InstallerId,
+		ProductTypeId:       job.ProductTypeId,
+		Runs:                job.Runs,
+		LicensedRuns:        job.LicensedRuns,
+		StartDate:           job.StartDate,
+		EndDate:             job.EndDate,
+		CompletedDate:       job.CompletedDate,
+	}
+}
+
+// syncCorpIndustryJobs fetches a source corp's industry jobs and stores them
+func (app *app) syncCorpIndustryJobs(ctx context.Context, logger *zap.Logger, corporationId int32) error {
+	start := time.Now()
+	logger = logger.Named("industry")
+
+	esiJobs, err := app.fetchCorpIndustryJobs(ctx, logger, corporationId)
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]industryJob, len(esiJobs))
+	for i, job := range esiJobs {
+		jobs[i] = industryJobFromEsi(corporationId, job)
+	}
+
+	if err = app.dao.upsertIndustryJobs(jobs); err != nil {
+		return err
+	}
+
+	logger.Info("synced corp industry jobs", zap.Int("jobs", len(jobs)), zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+func (app *app) fetchCorpIndustryJobs(ctx context.Context, logger *zap.Logger, corporationId int32) ([]esi.GetCorporationsCorporationIdIndustryJobs200Ok, error) {
+	start := time.Now()
+	jobs, err := esiPages(ctx, logger, func(ctx context.Context, page int32) ([]esi.GetCorporationsCorporationIdIndustryJobs200Ok, *http.Response, error) {
+		return app.esi.ESI.IndustryApi.GetCorporationsCorporationIdIndustryJobs(ctx, corporationId,
+			&esi.GetCorporationsCorporationIdIndustryJobsOpts{
+				// include completed jobs so that jobs finished since the last sync are updated
+				IncludeCompleted: optional.NewBool(true),
+				Page:             optional.NewInt32(page),
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching industry jobs: %w", err)
+	}
+
+	logger.Debug("fetched industry jobs", zap.Int("jobs", len(jobs)), zap.Duration("duration", time.Since(start)))
+	return jobs, nil
+}
+
+// list active copying and research jobs, with the blueprint each job is running on.
+// Filters by activity and corporation_id.
+func (app *app) listIndustryJobs(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+	logger.Debug("list industry jobs")
+
+	corporationId, err := parseInt32Param(r.URL.Query(), "corporation_id")
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activities := []int32{industryActivity_Copying, industryActivity_ResearchMaterial, industryActivity_ResearchTime}
+	if activity := r.URL.Query().Get("activity"); activity != "" {
+		activities = activities[:0]
+		for id, name := range industryActivity_name {
+			if name == activity {
+				activities = append(activities, id)
+			}
+		}
+		if len(activities) == 0 {
+			httpError(w, "invalid activity", http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := app.dao.listActiveIndustryJobs(corporationId, activities)
+	if err != nil {
+		logger.Error("error listing industry jobs", zap.Error(err))
+		httpError(w, "error listing industry jobs", http.StatusInternalServerError)
+		return
+	}
+
+	app.invStateLock.RLock()
+	defer app.invStateLock.RUnlock()
+
+	blueprints := make(map[int64]esi.GetCorporationsCorporationIdBlueprints200Ok, len(app.inventoryState.blueprints))
+	for _, bp := range app.inventoryState.blueprints {
+		blueprints[bp.ItemId] = bp
+	}
+
+	for i := range jobs {
+		jobs[i].BlueprintName = app.inventoryState.typeNames[jobs[i].BlueprintTypeId]
+		if bp, ok := blueprints[jobs[i].BlueprintId]; ok {
+			jobs[i].Blueprint = &industryJobBlueprint{
+				ItemId:             bp.ItemId,
+				MaterialEfficiency: bp.MaterialEfficiency,
+				TimeEfficiency:     bp.TimeEfficiency,
+				Runs:               bp.Runs,
+				LocationId:         bp.LocationId,
+			}
+		}
+	}
+
+	httpWrite(w, jobs)
+}
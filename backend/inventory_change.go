@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+const (
+	inventoryChangePageDefault = 100
+	inventoryChangePageMax     = 500
+)
+
+type inventoryChangeKind string
+
+const (
+	inventoryChange_Added   inventoryChangeKind = "added"   // a stack which wasn't held at the location before
+	inventoryChange_Removed inventoryChangeKind = "removed" // the last of a stack left the location
+	inventoryChange_Changed inventoryChangeKind = "changed" // the quantity of a stack changed
+	inventoryChange_Moved   inventoryChangeKind = "moved"   // an original was moved to another location
+)
+
+// inventoryChange is a difference found between two consecutive inventory syncs.
+// Copies are compared by the number held of each type and quality at a location, originals are also followed
+// by item id so moves can be told apart from one being removed and another added.
+type inventoryChange struct {
+	Id                 int64               `json:"id,omitempty"`
+	SyncedAt           time.Time           `json:"synced_at"`
+	Kind               inventoryChangeKind `json:"kind"`
+	TypeId             int32               `json:"type_id"`
+	TypeName           string              `json:"type_name,omitempty"`
+	Copy               bool                `json:"copy"`
+	Runs               int32               `json:"runs"`
+	MaterialEfficiency int32               `json:"me"`
+	TimeEfficiency     int32               `json:"te"`
+	Location           itemLocation        `json:"location"`
+	From               *itemLocation       `json:"from,omitempty"` // where a moved original was before
+	QuantityBefore     int32               `json:"quantity_before"`
+	QuantityAfter      int32               `json:"quantity_after"`
+}
+
+// inventoryStockKey identifies a stack of identical blueprints at a location
+type inventoryStockKey struct {
+	quality  blueprintQuality
+	copy     bool
+	location itemLocation // without names, which may be renamed between syncs
+}
+
+func isBlueprintCopy(bp esi.GetCorporationsCorporationIdBlueprints200Ok) bool {
+	return bp.Quantity == -2
+}
+
+// blueprintCount is the number of blueprints an esi blueprint item stands for
+func blueprintCount(bp esi.GetCorporationsCorporationIdBlueprints200Ok) int32 {
+	if bp.Quantity < 0 { // -1 researched original, -2 copy
+		return 1
+	}
+	return bp.Quantity
+}
+
+// stockLocation is where a blueprint is held, without names
+func (inv *inventoryState) stockLocation(bp esi.GetCorporationsCorporationIdBlueprints200Ok) itemLocation {
+	loc := inv.locate(bp.ItemId, bp.LocationId, bp.LocationFlag)
+	loc.DivisionName = ""
+	loc.ContainerName = ""
+	return loc
+}
+
+// stock totals the blueprints of corps by type, quality and location, leaving out the items in skip
+func (inv *inventoryState) stock(corps []int32, skip map[int64]bool) map[inventoryStockKey]int32 {
+	stock := map[inventoryStockKey]int32{}
+	for _, bp := range inv.blueprints {
+		if skip[bp.ItemId] || !slices.Contains(corps, inv.owners[bp.ItemId]) {
+			continue
+		}
+		key := inventoryStockKey{
+			quality:  qualityOfBlueprint(bp),
+			copy:     isBlueprintCopy(bp),
+			location: inv.stockLocation(bp),
+		}
+		stock[key] += blueprintCount(bp)
+	}
+	return stock
+}
+
+// diffInventory finds what changed between prev and next. Only corps present in both are compared, so a corp
+// which is skipped for a missing token doesn't appear to lose its whole inventory.
+func diffInventory(prev, next *inventoryState) []inventoryChange {
+	var corps []int32
+	for _, corporationId := range next.corporations {
+		if slices.Contains(prev.corporations, corporationId) {
+			corps = append(corps, corporationId)
+		}
+	}
+
+	newChange := func(kind inventoryChangeKind, key inventoryStockKey, before, after int32) inventoryChange {
+		return inventoryChange{
+			SyncedAt:           next.updatedAt,
+			Kind:               kind,
+			TypeId:             key.quality.TypeId,
+			TypeName:           cmp.Or(next.typeNames[key.quality.TypeId], prev.typeNames[key.quality.TypeId]),
+			Copy:               key.copy,
+			Runs:               key.quality.Runs,
+			MaterialEfficiency: key.quality.MaterialEfficiency,
+			TimeEfficiency:     key.quality.TimeEfficiency,
+			Location:           key.location,
+			QuantityBefore:     before,
+			QuantityAfter:      after,
+		}
+	}
+
+	originals := map[int64]esi.GetCorporationsCorporationIdBlueprints200Ok{}
+	for _, bp := range prev.blueprints {
+		if !isBlueprintCopy(bp) && slices.Contains(corps, prev.owners[bp.ItemId]) {
+			originals[bp.ItemId] = bp
+		}
+	}
+
+	var changes []inventoryChange
+	moved := map[int64]bool{}
+	for _, bp := range next.blueprints {
+		old, ok := originals[bp.ItemId]
+		if !ok || isBlueprintCopy(bp) || !slices.Contains(corps, next.owners[bp.ItemId]) {
+			continue
+		}
+		from, to := prev.stockLocation(old), next.stockLocation(bp)
+		if from == to {
+			continue
+		}
+		moved[bp.ItemId] = true
+
+		key := inventoryStockKey{quality: qualityOfBlueprint(bp), location: to}
+		change := newChange(inventoryChange_Moved, key, blueprintCount(old), blueprintCount(bp))
+		change.From = &from
+		changes = append(changes, change)
+	}
+
+	before, after := prev.stock(corps, moved), next.stock(corps, moved)
+	for key, qty := range after {
+		switch was, ok := before[key]; {
+		case !ok:
+			changes = append(changes, newChange(inventoryChange_Added, key, 0, qty))
+		case was != qty:
+			changes = append(changes, newChange(inventoryChange_Changed, key, was, qty))
+		}
+	}
+	for key, qty := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, newChange(inventoryChange_Removed, key, qty, 0))
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b inventoryChange) int {
+		return cmp.Or(
+			cmp.Compare(a.TypeId, b.TypeId),
+			cmp.Compare(a.Location.CorporationId, b.Location.CorporationId),
+			cmp.Compare(a.Location.LocationId, b.Location.LocationId),
+			cmp.Compare(a.Location.Division, b.Location.Division),
+			cmp.Compare(a.Location.ContainerId, b.Location.ContainerId),
+			cmp.Compare(a.Runs, b.Runs),
+			cmp.Compare(a.MaterialEfficiency, b.MaterialEfficiency),
+			cmp.Compare(a.TimeEfficiency, b.TimeEfficiency),
+			strings.Compare(string(a.Kind), string(b.Kind)))
+	})
+	return changes
+}
+
+// inventoryChangeFilter selects a page of changes, newest first. Zero value fields don't filter.
+type inventoryChangeFilter struct {
+	Kinds         []inventoryChangeKind
+	TypeId        int32
+	CorporationId int32
+	LocationId    int64
+	Copy          *bool
+	From          time.Time
+	To            time.Time
+	Limit         int
+	Cursor        int64 // id of the last change on the previous page
+}
+
+func parseInventoryChangeFilter(query url.Values) (*inventoryChangeFilter, error) {
+	f := &inventoryChangeFilter{Limit: inventoryChangePageDefault}
+
+	for _, param := range query["kind"] {
+		for s := range strings.SplitSeq(param, ",") {
+			switch kind := inventoryChangeKind(strings.TrimSpace(s)); kind {
+			case inventoryChange_Added, inventoryChange_Removed, inventoryChange_Changed, inventoryChange_Moved:
+				f.Kinds = append(f.Kinds, kind)
+			default:
+				return nil, fmt.Errorf("invalid kind %q", s)
+			}
+		}
+	}
+
+	var err error
+	if f.TypeId, err = parseInt32Param(query, "type_id"); err != nil {
+		return nil, err
+	}
+	if f.CorporationId, err = parseInt32Param(query, "corporation_id"); err != nil {
+		return nil, err
+	}
+	if s := query.Get("location_id"); s != "" {
+		if f.LocationId, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, errors.New("invalid location_id")
+		}
+	}
+	if s := query.Get("copy"); s != "" {
+		isCopy, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("invalid copy")
+		}
+		f.Copy = &isCopy
+	}
+
+	if f.From, err = parseTimeParam(query.Get("from"), time.Time{}); err != nil {
+		return nil, fmt.Errorf("invalid from: %w", err)
+	}
+	if f.To, err = parseTimeParam(query.Get("to"), time.Time{}); err != nil {
+		return nil, fmt.Errorf("invalid to: %w", err)
+	}
+
+	if s := query.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 1 || f.Limit > inventoryChangePageMax {
+			return nil, fmt.Errorf("limit must be between 1 and %d", inventoryChangePageMax)
+		}
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		if f.Cursor, err = strconv.ParseInt(s, 10, 64); err != nil || f.Cursor <= 0 {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	return f, nil
+}
+
+// list a page of inventory changes, newest first. Filters by kind, type_id, corporation_id, location_id,
+// copy and synced between from and to. The cursor for the next page is returned in the X-Next-Cursor header.
+func (app *app) listInventoryChanges(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	filter, err := parseInventoryChangeFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changes, next, err := app.dao.queryInventoryChanges(filter)
+	if err != nil {
+		logger.Error("error listing inventory changes", zap.Error(err))
+		httpError(w, "error listing inventory changes", http.StatusInternalServerError)
+		return
+	}
+
+	// names aren't stored with the change, use the current ones
+	app.invStateLock.RLock()
+	for i := range changes {
+		changes[i].Location = app.inventoryState.named(changes[i].Location)
+		if from := changes[i].From; from != nil {
+			*from = app.inventoryState.named(*from)
+		}
+	}
+	app.invStateLock.RUnlock()
+
+	if next != "" {
+		w.Header().Set(headerNextCursor, next)
+	}
+	httpWrite(w, changes)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+)
+
+const (
+	headerLastModified = "Last-Modified"
+	headerSnapshotAge  = "X-Snapshot-Age" // seconds since the inventory was fetched from esi
+
+	inventorySnapshotsKept = 3
+)
+
+// inventorySnapshot is the stored form of an inventoryState. The bpo and bpc stacks aren't stored, they
+// are rebuilt from Blueprints with the config in use when it is restored.
+type inventorySnapshot struct {
+	UpdatedAt      time.Time                                         `json:"updated_at"`
+	FullRefreshAt  time.Time                                         `json:"full_refresh_at"`
+	Blueprints     []esi.GetCorporationsCorporationIdBlueprints200Ok `json:"blueprints"`
+	Assets         []esi.GetCorporationsCorporationIdAssets200Ok     `json:"assets"`
+	Owners         map[int64]int32                                   `json:"owners"`
+	Corporations   []int32                                           `json:"corporations"`
+	ContainerNames map[int64]string                                  `json:"container_names"`
+	HangarNames    map[int32][]string                                `json:"hangar_names"`
+	TypeNames      map[int32]string                                  `json:"type_names"`
+}
+
+func (inv *inventoryState) snapshot() *inventorySnapshot {
+	s := &inventorySnapshot{
+		UpdatedAt:      inv.updatedAt,
+		FullRefreshAt:  inv.fullRefreshAt,
+		Blueprints:     inv.blueprints,
+		Assets:         inv.assets,
+		Owners:         inv.owners,
+		Corporations:   inv.corporations,
+		ContainerNames: inv.containerNames,
+		HangarNames:    inv.hangarNames,
+		TypeNames:      inv.typeNames,
+	}
+	return s
+}
+
+// restoreInventory rebuilds an inventoryState from a snapshot, filtering its blueprints with the current config
+// in case the source corps or locations changed since it was taken. Its names are reused until the next full refresh.
+func (app *app) restoreInventory(s *inventorySnapshot) *inventoryState {
+	inv := &inventoryState{
+		updatedAt:      s.UpdatedAt,
+		fullRefreshAt:  s.FullRefreshAt,
+		blueprints:     s.Blueprints,
+		assets:         s.Assets,
+		bpcs:           make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+		bpcItems:       make(map[blueprintQuality][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+		bpcSources:     make(map[blueprintQuality][]blueprintSource),
+		bpos:           make(map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok),
+		owners:         s.Owners,
+		config:         app.config,
+		corporations:   s.Corporations,
+		containerNames: s.ContainerNames,
+		hangarNames:    s.HangarNames,
+		typeNames:      s.TypeNames,
+	}
+	inv.tree = app.buildAssetTree(inv.assets)
+
+	for _, bp := range inv.blueprints {
+		if !inv.config.isSourceCorp(inv.owners[bp.ItemId]) || !inv.inSourceLocation(inv.config, bp.ItemId, bp.LocationId, bp.LocationFlag) {
+			continue
+		}
+		inv.catalogue(bp)
+	}
+	for q, items := range inv.bpcItems {
+		inv.bpcSources[q] = inv.sourcesOf(items)
+	}
+
+	return inv
+}
+
+func encodeInventorySnapshot(s *inventorySnapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return nil, fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeInventorySnapshot(data []byte) (*inventorySnapshot, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing snapshot: %w", err)
+	}
+	defer zr.Close()
+
+	s := &inventorySnapshot{}
+	if err = json.NewDecoder(zr).Decode(s); err != nil {
+		return nil, fmt.Errorf("error decoding snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// writeInventoryAge sets headers describing how fresh the inventory is.
+// Callers must hold app.invStateLock.
+func (app *app) writeInventoryAge(w http.ResponseWriter) {
+	updatedAt := app.inventoryState.updatedAt
+	if updatedAt.IsZero() {
+		return
+	}
+	w.Header().Set(headerLastModified, updatedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set(headerSnapshotAge, strconv.Itoa(int(time.Since(updatedAt).Seconds())))
+}
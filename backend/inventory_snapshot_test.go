@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/antihax/goesi/esi"
+)
+
+func TestRestoreInventoryUsesCurrentConfig(t *testing.T) {
+	const station, otherCorp = 60003760, 98000002
+
+	copyIn := func(itemId int64, typeId int32, flag string) esi.GetCorporationsCorporationIdBlueprints200Ok {
+		return esi.GetCorporationsCorporationIdBlueprints200Ok{
+			ItemId: itemId, TypeId: typeId, LocationId: station, LocationFlag: flag,
+			Quantity: -2, Runs: 10, MaterialEfficiency: 10, TimeEfficiency: 20,
+		}
+	}
+	snapshot := &inventorySnapshot{
+		Blueprints: []esi.GetCorporationsCorporationIdBlueprints200Ok{
+			copyIn(1, 1000, "CorpSAG1"),
+			copyIn(2, 1000, "CorpSAG1"),
+			copyIn(3, 1000, "CorpSAG2"), // outside the source division
+			copyIn(4, 2000, "CorpSAG1"), // held by a corp that is no longer a source
+			{ItemId: 5, TypeId: 3000, LocationId: station, LocationFlag: "CorpSAG1", Quantity: -1},
+		},
+		Owners: map[int64]int32{1: testSourceCorp, 2: testSourceCorp, 3: testSourceCorp, 4: otherCorp, 5: testSourceCorp},
+	}
+
+	app := &app{config: &appConfig{
+		SourceDivisions: []int{1},
+		SourceCorps:     []sourceCorp{{CorporationId: testSourceCorp, CharacterId: 90000001}},
+	}}
+	inv := app.restoreInventory(snapshot)
+
+	if inv.config != app.config {
+		t.Error("restored inventory isn't tagged with the current config")
+	}
+	if stacks := inv.bpcs[1000]; len(stacks) != 1 || stacks[0].Quantity != 2 {
+		t.Errorf("type 1000 restored as %+v, want a single stack of 2", stacks)
+	}
+	if stacks, ok := inv.bpcs[2000]; ok {
+		t.Errorf("type 2000 restored as %+v, want it dropped with its corp", stacks)
+	}
+	if stacks := inv.bpos[3000]; len(stacks) != 1 {
+		t.Errorf("type 3000 restored as %+v, want a single original", stacks)
+	}
+
+	q := qualityOfBlueprint(copyIn(0, 1000, ""))
+	want := []blueprintSource{{CorporationId: testSourceCorp, LocationId: station, Quantity: 2}}
+	if got := inv.bpcSources[q]; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("type 1000 sources = %+v, want %+v", got, want)
+	}
+}
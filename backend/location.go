@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlHeamer/brave-bpc/glue"
+	"github.com/antihax/goesi/esi"
+)
+
+const (
+	maxAssetDepth = 10
+	corpDivisions = 7
+)
+
+// itemLocation is where an item sits in the corp's hangars
+type itemLocation struct {
+	CorporationId int32  `json:"corporation_id,omitempty"`
+	LocationId    int64  `json:"location_id"` // station or structure
+	Division      int    `json:"division,omitempty"`
+	DivisionName  string `json:"division_name,omitempty"`
+	ContainerId   int64  `json:"container_id,omitempty"`
+	ContainerName string `json:"container_name,omitempty"`
+}
+
+// locate walks up the asset tree from an item's location to find the owning corp, hangar division, innermost
+// container and station holding it.
+func (inv *inventoryState) locate(itemId int64, locationId int64, locationFlag string) itemLocation {
+	loc := itemLocation{CorporationId: inv.owners[itemId], Division: corpDivision(locationFlag)}
+
+	id := locationId
+	for range maxAssetDepth {
+		node, ok := inv.tree[id]
+		if !ok || node.Asset == nil {
+			break
+		}
+
+		asset := node.Asset
+		if asset.LocationFlag != string(glue.LocationFlag_OfficeFolder) {
+			if loc.ContainerId == 0 {
+				loc.ContainerId = asset.ItemId
+			}
+			if loc.Division == 0 {
+				loc.Division = corpDivision(asset.LocationFlag)
+			}
+		}
+		id = asset.LocationId
+	}
+	loc.LocationId = id
+
+	return inv.named(loc)
+}
+
+// named fills in the division and container names of loc
+func (inv *inventoryState) named(loc itemLocation) itemLocation {
+	if loc.ContainerId != 0 {
+		loc.ContainerName = inv.containerNames[loc.ContainerId]
+	}
+	if names := inv.hangarNames[loc.CorporationId]; loc.Division > 0 && loc.Division <= len(names) {
+		loc.DivisionName = names[loc.Division-1]
+	}
+	return loc
+}
+
+// sourcesOf totals blueprint copies by owning corp and station
+func (inv *inventoryState) sourcesOf(items []esi.GetCorporationsCorporationIdBlueprints200Ok) []blueprintSource {
+	var sources []blueprintSource
+	for _, item := range items {
+		src := blueprintSource{
+			CorporationId: inv.owners[item.ItemId],
+			LocationId:    inv.locate(item.ItemId, item.LocationId, item.LocationFlag).LocationId,
+		}
+		idx := slices.IndexFunc(sources, func(e blueprintSource) bool {
+			return e.CorporationId == src.CorporationId && e.LocationId == src.LocationId
+		})
+		if idx == -1 {
+			sources = append(sources, src)
+			idx = len(sources) - 1
+		}
+		sources[idx].Quantity++
+	}
+	return sources
+}
+
+// inSourceLocation reports if an item is in one of the structures, hangar divisions and containers
+// blueprints are handed out from
+func (inv *inventoryState) inSourceLocation(config *appConfig, itemId int64, locationId int64, locationFlag string) bool {
+	loc := inv.locate(itemId, locationId, locationFlag)
+	if len(config.SourceStructures) > 0 && !slices.Contains(config.SourceStructures, loc.LocationId) {
+		return false
+	}
+	if len(config.SourceDivisions) > 0 && !slices.Contains(config.SourceDivisions, loc.Division) {
+		return false
+	}
+	if len(config.SourceContainers) == 0 {
+		return true
+	}
+
+	// containers may be nested, any of them can be a source
+	id := locationId
+	for range maxAssetDepth {
+		if slices.Contains(config.SourceContainers, id) {
+			return true
+		}
+		node, ok := inv.tree[id]
+		if !ok || node.Asset == nil {
+			break
+		}
+		id = node.Asset.LocationId
+	}
+	return false
+}
+
+// corpDivision returns the hangar division of a CorpSAG location flag, or 0
+func corpDivision(locationFlag string) int {
+	n, ok := strings.CutPrefix(locationFlag, "CorpSAG")
+	if !ok {
+		return 0
+	}
+	division, _ := strconv.Atoi(n)
+	return division
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
@@ -25,29 +28,63 @@ const (
 	esiUserAgent = "brave-bpc/0.0.0 (eve:Al Heamer)"
 
 	headerPages       = "X-Pages"
-	esiRequestTimeout = 20 * time.Second
+	esiRequestTimeout = 20 * time.Second // per attempt, including any wait for the esi error budget
 )
 
 type appConfig struct {
-	AllianceWhitelist    []int32 `json:"alliances,omitempty"`     // Alliances allowed to log into this service
-	CorporationWhitelist []int32 `json:"corporations,omitempty"`  // Corporations allowed to log into this service
-	AdminCorp            int32   `json:"admin_corp,omitempty"`    // Corporation that provides the service
-	AdminCharacter       int32   `json:"admin_char,omitempty"`    // The character used to poll corporate data
-	MaxContracts         int32   `json:"max_contracts,omitempty"` // Maximum number of contracts an account can open
+	AllianceWhitelist    []int32      `json:"alliances,omitempty"`           // Alliances allowed to log into this service
+	CorporationWhitelist []int32      `json:"corporations,omitempty"`        // Corporations allowed to log into this service
+	AdminCorp            int32        `json:"admin_corp,omitempty"`          // Corporation that provides the service
+	AdminCharacter       int32        `json:"admin_char,omitempty"`          // The character used to poll corporate data
+	MaxContracts         int32        `json:"max_contracts,omitempty"`       // Maximum number of open requisitions an account can have
+	MaxBlueprints        int32        `json:"max_blueprints,omitempty"`      // Maximum number of blueprint copies in a single requisition
+	CapitalBlueprints    []int32      `json:"capital_blueprints,omitempty"`  // Blueprint type ids which count towards the capital limit
+	CapitalLimit         int32        `json:"capital_limit,omitempty"`       // Capital blueprints an account can request per CapitalPeriodDays
+	CapitalPeriodDays    int32        `json:"capital_period_days,omitempty"` // Length of the capital limit window in days
+	AllocationStrategy   string       `json:"allocation_strategy,omitempty"` // How stock is picked for any quality lines, see allocationOrder
+	SourceStructures     []int64      `json:"source_structures,omitempty"`   // Stations or structures blueprints are handed out from, empty for any
+	SourceDivisions      []int        `json:"source_divisions,omitempty"`    // Corp hangar divisions (1-7) blueprints are handed out from, empty for any
+	SourceContainers     []int64      `json:"source_containers,omitempty"`   // Containers blueprints must be inside, empty for any
+	SourceCorps          []sourceCorp `json:"source_corps,omitempty"`        // Corporations which stock blueprints, defaults to AdminCorp
+}
+
+// sourceCorp is a corporation holding blueprint stock, polled with its director character's token
+type sourceCorp struct {
+	CorporationId int32 `json:"corporation_id"`
+	CharacterId   int32 `json:"character_id"`
+}
+
+// sourceCorps returns the corporations to poll for blueprints, the admin corp if none are configured
+func (c *appConfig) sourceCorps() []sourceCorp {
+	if len(c.SourceCorps) == 0 {
+		return []sourceCorp{{CorporationId: c.AdminCorp, CharacterId: c.AdminCharacter}}
+	}
+	return c.SourceCorps
+}
+
+func (c *appConfig) isSourceCorp(corporationId int32) bool {
+	return slices.ContainsFunc(c.sourceCorps(), func(sc sourceCorp) bool {
+		return sc.CorporationId == corporationId
+	})
 }
 
 type runtimeConfig struct {
-	appId       string
-	appSecret   string
-	appRedirect string
-	environment string
-	migrateDown string
-	httpPort    string
-	jwtSkew     time.Duration
+	appId        string
+	appSecret    string
+	appRedirect  string
+	environment  string
+	migrateDown  string
+	httpPort     string
+	jwtSkew      time.Duration
+	esiBasePath  string // overrides the ESI host, eg. a local stand-in for testing
+	alertWebhook string
+	sdePath      string // directory of an sde jsonl dump to import on startup
 }
 
 type requisitionLock struct {
+	RequisitionId int64     `json:"requisition_id,omitempty"`
 	LockedAt      time.Time `json:"locked_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
 	CharacterId   int32     `json:"character_id"`
 	CharacterName string    `json:"character_name"`
 }
@@ -59,10 +96,12 @@ type app struct {
 	dao            *dao
 	sessionStore   sessions.Store
 	esi            *goesi.APIClient
+	esiCache       *esiCache
 	invStateLock   sync.RWMutex
 	inventoryState *inventoryState
+	notifier       notifier
+	stockAlerted   map[int64]bool // stock targets already alerted on, only accessed by the ticker
 
-	requisitionLocks      *syncMap[int64, requisitionLock]
 	flake                 *snowflake.Node
 	jwks                  *EsiJwks
 	adminTokenRefreshChan chan struct{}
@@ -78,24 +117,34 @@ func main() {
 	}
 
 	var err error
+	cache := newEsiCache(newEsiTransport(http.DefaultTransport))
 	app := &app{
 		logger:       logger,
 		sessionStore: newSessionStore(),
-		esi:          goesi.NewAPIClient(&http.Client{Timeout: 10 * time.Second}, esiUserAgent),
+		// no client timeout, every esi request is made by esiCall with esiRequestTimeout
+		esi:          goesi.NewAPIClient(&http.Client{Transport: cache}, esiUserAgent),
+		esiCache:     cache,
 		flake:        newSnowflake(logger),
 		invStateLock: sync.RWMutex{},
 		inventoryState: &inventoryState{
 			bpcs:           map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok{},
 			bpos:           map[int32][]esi.GetCorporationsCorporationIdBlueprints200Ok{},
+			owners:         map[int64]int32{},
 			containerNames: map[int64]string{},
+			hangarNames:    map[int32][]string{},
 			typeNames:      map[int32]string{},
 			tree:           map[int64]*CorpAsset{},
 		},
-		requisitionLocks:      newSyncMap[int64, requisitionLock](),
 		runtimeConfig:         runtimeConfig,
+		notifier:              newNotifier(logger, runtimeConfig.alertWebhook),
 		adminTokenRefreshChan: make(chan struct{}, 1),
 	}
 
+	if runtimeConfig.esiBasePath != "" {
+		logger.Warn("using alternate esi base path", zap.String("path", runtimeConfig.esiBasePath))
+		app.esi.ChangeBasePath(runtimeConfig.esiBasePath)
+	}
+
 	threadCtx, cancelThreads := context.WithCancel(context.Background())
 	app.jwks, err = NewEsiJwks(threadCtx,
 		app.runtimeConfig.appId,
@@ -115,6 +164,24 @@ func main() {
 		logger.Fatal("failed to load config from db", zap.Error(err))
 	}
 
+	if app.runtimeConfig.sdePath != "" {
+		// names fall back to esi until the import finishes
+		go func() {
+			if err := app.importSde(logger.Named("sde"), app.runtimeConfig.sdePath); err != nil {
+				logger.Error("error importing sde", zap.String("path", app.runtimeConfig.sdePath), zap.Error(err))
+			}
+		}()
+	}
+
+	if snapshot, err := app.dao.loadInventorySnapshot(); errors.Is(err, sql.ErrNoRows) {
+		logger.Info("no inventory snapshot, waiting for the first esi sync")
+	} else if err != nil {
+		logger.Error("error loading inventory snapshot", zap.Error(err))
+	} else {
+		app.inventoryState = app.restoreInventory(snapshot)
+		logger.Info("restored inventory snapshot", zap.Time("updated_at", snapshot.UpdatedAt))
+	}
+
 	gob.Register(user{})
 	gob.Register(sessionAuthType{})
 	gob.Register(sessionLoginScopes{})
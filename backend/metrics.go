@@ -14,6 +14,18 @@ var (
 		Name: "blueprint_fetch_http_duration_seconds",
 		Help: "Time taken to collect all blueprint pages",
 	})
+	esiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "esi_request_duration_seconds",
+		Help: "Duration of requests sent to ESI by endpoint and status code.",
+	}, []string{"endpoint", "status"})
+	esiCacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "esi_cache_results_total",
+		Help: "ESI GET requests by endpoint and cache status.",
+	}, []string{"endpoint", "cache"})
+	esiErrorLimitRemain = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "esi_error_limit_remain",
+		Help: "Errors ESI will accept before the error limit window resets.",
+	})
 	logCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "bpc_log_count",
 		Help: "The number of logs (by type) that have been fired",
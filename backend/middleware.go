@@ -87,6 +87,7 @@ func apiMiddleware(next http.Handler) http.Handler {
 		if os.Getenv(envEnvironment) == "dev" {
 			w.Header().Add("Access-Control-Allow-Origin", "http://localhost:3000")
 			w.Header().Add("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Access-Control-Expose-Headers", headerNextCursor+", "+headerLastModified+", "+headerSnapshotAge)
 		}
 		next.ServeHTTP(w, r)
 	})
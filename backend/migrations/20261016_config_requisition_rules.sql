@@ -0,0 +1,13 @@
+-- +goose Up
+UPDATE config SET config = JSON_SET(config,
+	'$.max_blueprints', 10,
+	'$.capital_limit', 1,
+	'$.capital_period_days', 30
+);
+
+-- +goose Down
+UPDATE config SET config = JSON_REMOVE(config,
+	'$.max_blueprints',
+	'$.capital_limit',
+	'$.capital_period_days'
+);
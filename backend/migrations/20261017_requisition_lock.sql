@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE requisition_lock(
+	requisition_id BIGINT NOT NULL,
+	character_id   INTEGER NOT NULL,
+	character_name VARCHAR(64) NOT NULL,
+	locked_at      DATETIME NOT NULL DEFAULT NOW(),
+	expires_at     DATETIME NOT NULL,
+	PRIMARY KEY (requisition_id),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE CASCADE -- deleted when requisition_order.id deleted
+);
+
+-- +goose Down
+DROP TABLE requisition_lock;
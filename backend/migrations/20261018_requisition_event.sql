@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE requisition_event(
+	id             BIGINT AUTO_INCREMENT NOT NULL,
+	requisition_id BIGINT NOT NULL,
+	action         VARCHAR(16) NOT NULL,
+	character_id   INTEGER NOT NULL,
+	character_name VARCHAR(64) NOT NULL,
+	old_status     TINYINT NOT NULL,
+	new_status     TINYINT NOT NULL,
+	notes          TEXT,
+	created_at     DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id),
+	INDEX (requisition_id, created_at),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE CASCADE -- deleted when requisition_order.id deleted
+);
+
+-- requisitions created before history was recorded
+INSERT INTO requisition_event
+(requisition_id, action, character_id, character_name, old_status, new_status, created_at)
+SELECT id, 'create', character_id, character_name, 0, 1, created_at
+FROM requisition_order;
+
+-- +goose Down
+DROP TABLE requisition_event;
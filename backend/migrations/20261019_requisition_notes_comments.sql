@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE requisition_order ADD private_notes TEXT;
+ALTER TABLE requisition_event ADD private_notes TEXT;
+
+CREATE TABLE requisition_comment(
+	id             BIGINT AUTO_INCREMENT NOT NULL,
+	requisition_id BIGINT NOT NULL,
+	parent_id      BIGINT,
+	character_id   INTEGER NOT NULL,
+	character_name VARCHAR(64) NOT NULL,
+	body           TEXT NOT NULL,
+	created_at     DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id),
+	INDEX (requisition_id, created_at),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE CASCADE, -- deleted when requisition_order.id deleted
+	FOREIGN KEY (parent_id) REFERENCES requisition_comment(id) ON DELETE CASCADE    -- replies deleted with their parent
+);
+
+-- +goose Down
+DROP TABLE requisition_comment;
+ALTER TABLE requisition_event DROP COLUMN private_notes;
+ALTER TABLE requisition_order DROP COLUMN private_notes;
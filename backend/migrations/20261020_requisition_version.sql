@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE requisition_order ADD version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE requisition_version(
+	requisition_id BIGINT NOT NULL,
+	version        INTEGER NOT NULL,
+	blueprints     JSON NOT NULL,
+	created_at     DATETIME NOT NULL DEFAULT NOW(),
+	created_by     VARCHAR(64) NOT NULL,
+	PRIMARY KEY (requisition_id, version),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE CASCADE -- deleted when requisition_order.id deleted
+);
+
+INSERT INTO requisition_version
+(requisition_id, version, blueprints, created_at, created_by)
+SELECT id, 1, blueprints, created_at, character_name
+FROM requisition_order;
+
+-- +goose Down
+DROP TABLE requisition_version;
+ALTER TABLE requisition_order DROP COLUMN version;
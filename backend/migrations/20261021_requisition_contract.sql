@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE requisition_contract(
+	contract_id     INTEGER NOT NULL,
+	requisition_id  BIGINT,
+	match_result    VARCHAR(16) NOT NULL,
+	issuer_id       INTEGER NOT NULL,
+	assignee_id     INTEGER NOT NULL,
+	contract_status VARCHAR(32) NOT NULL,
+	notes           TEXT,
+	processed_at    DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (contract_id),
+	INDEX (requisition_id),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE SET NULL -- keep the contract processed when the requisition is deleted
+);
+
+-- +goose Down
+DROP TABLE requisition_contract;
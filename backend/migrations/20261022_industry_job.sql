@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE industry_job(
+	job_id                INTEGER NOT NULL,
+	activity_id           INTEGER NOT NULL,
+	job_status            VARCHAR(16) NOT NULL,
+	blueprint_id          BIGINT NOT NULL,
+	blueprint_type_id     INTEGER NOT NULL,
+	blueprint_location_id BIGINT NOT NULL,
+	facility_id           BIGINT NOT NULL,
+	installer_id          INTEGER NOT NULL,
+	product_type_id       INTEGER NOT NULL DEFAULT 0,
+	runs                  INTEGER NOT NULL,
+	licensed_runs         INTEGER NOT NULL DEFAULT 0,
+	start_date            DATETIME NOT NULL,
+	end_date              DATETIME NOT NULL,
+	completed_date        DATETIME,
+	updated_at            DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (job_id),
+	INDEX (job_status, activity_id)
+);
+
+-- +goose Down
+DROP TABLE industry_job;
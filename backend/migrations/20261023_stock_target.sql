@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE stock_target(
+	id           BIGINT AUTO_INCREMENT NOT NULL,
+	type_id      INTEGER NOT NULL,
+	min_runs     INTEGER NOT NULL DEFAULT 0,
+	min_me       INTEGER NOT NULL DEFAULT 0,
+	min_te       INTEGER NOT NULL DEFAULT 0,
+	target       INTEGER NOT NULL,
+	updated_at   DATETIME NOT NULL DEFAULT NOW(),
+	updated_by   VARCHAR(64) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE INDEX (type_id, min_runs, min_me, min_te)
+);
+
+-- +goose Down
+DROP TABLE stock_target;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE requisition_type(
+	requisition_id BIGINT NOT NULL,
+	type_id        INTEGER NOT NULL,
+	PRIMARY KEY (requisition_id, type_id),
+	INDEX (type_id),
+	FOREIGN KEY (requisition_id) REFERENCES requisition_order(id) ON DELETE CASCADE -- deleted when requisition_order.id deleted
+);
+
+-- index the types of existing requisitions. seq_0_to_999 is provided by the mariadb sequence engine
+INSERT IGNORE INTO requisition_type
+(requisition_id, type_id)
+SELECT o.id, JSON_VALUE(o.blueprints, CONCAT('$[', s.seq, '].type_id'))
+FROM requisition_order o
+JOIN seq_0_to_999 s ON s.seq < JSON_LENGTH(o.blueprints);
+
+CREATE INDEX requisition_order_status_created ON requisition_order (requisition_status, created_at, id);
+CREATE INDEX requisition_order_status_updated ON requisition_order (requisition_status, updated_at, id);
+CREATE INDEX requisition_order_character_created ON requisition_order (character_id, created_at, id);
+CREATE INDEX requisition_order_updated_by ON requisition_order (updated_by, updated_at);
+CREATE INDEX requisition_lock_character ON requisition_lock (character_id, expires_at);
+
+-- +goose Down
+DROP INDEX requisition_lock_character ON requisition_lock;
+DROP INDEX requisition_order_updated_by ON requisition_order;
+DROP INDEX requisition_order_character_created ON requisition_order;
+DROP INDEX requisition_order_status_updated ON requisition_order;
+DROP INDEX requisition_order_status_created ON requisition_order;
+DROP TABLE requisition_type;
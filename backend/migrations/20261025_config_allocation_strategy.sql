@@ -0,0 +1,9 @@
+-- +goose Up
+UPDATE config SET config = JSON_SET(config,
+	'$.allocation_strategy', 'best_quality'
+);
+
+-- +goose Down
+UPDATE config SET config = JSON_REMOVE(config,
+	'$.allocation_strategy'
+);
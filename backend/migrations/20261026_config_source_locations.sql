@@ -0,0 +1,11 @@
+-- +goose Up
+UPDATE config SET config = JSON_SET(config,
+	'$.source_structures', JSON_ARRAY(1047935338899),
+	'$.source_divisions', JSON_ARRAY(7)
+);
+
+-- +goose Down
+UPDATE config SET config = JSON_REMOVE(config,
+	'$.source_structures',
+	'$.source_divisions'
+);
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE requisition_order ADD COLUMN corporation_id INTEGER AFTER version;
+ALTER TABLE requisition_contract ADD COLUMN corporation_id INTEGER NOT NULL DEFAULT 0 AFTER match_result;
+
+-- everything so far was handed out by the admin corp, fill in completed and partially completed requisitions
+UPDATE requisition_order
+SET corporation_id = (SELECT JSON_VALUE(config, '$.admin_corp') FROM config LIMIT 1)
+WHERE requisition_status IN (3, 5);
+
+UPDATE requisition_contract
+SET corporation_id = (SELECT JSON_VALUE(config, '$.admin_corp') FROM config LIMIT 1);
+
+-- +goose Down
+ALTER TABLE requisition_contract DROP COLUMN corporation_id;
+ALTER TABLE requisition_order DROP COLUMN corporation_id;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE inventory_snapshot(
+	id           BIGINT AUTO_INCREMENT NOT NULL,
+	updated_at   DATETIME NOT NULL, -- when the inventory was fetched from esi
+	data         LONGBLOB NOT NULL, -- gzipped json, see inventorySnapshot
+	created_at   DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id)
+);
+
+-- +goose Down
+DROP TABLE inventory_snapshot;
@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE inventory_change(
+	id                BIGINT AUTO_INCREMENT NOT NULL,
+	synced_at         DATETIME NOT NULL, -- updated_at of the inventory the change was found in
+	kind              VARCHAR(16) NOT NULL,
+	type_id           INTEGER NOT NULL,
+	type_name         VARCHAR(128) NOT NULL DEFAULT '',
+	is_copy           BOOLEAN NOT NULL,
+	runs              INTEGER NOT NULL,
+	me                INTEGER NOT NULL,
+	te                INTEGER NOT NULL,
+	corporation_id    INTEGER NOT NULL,
+	location_id       BIGINT NOT NULL,
+	division          TINYINT NOT NULL,
+	container_id      BIGINT NOT NULL,
+	from_location_id  BIGINT,  -- moved blueprints only
+	from_division     TINYINT,
+	from_container_id BIGINT,
+	quantity_before   INTEGER NOT NULL,
+	quantity_after    INTEGER NOT NULL,
+	PRIMARY KEY (id),
+	INDEX (synced_at),
+	INDEX (type_id, id)
+);
+
+-- +goose Down
+DROP TABLE inventory_change;
@@ -0,0 +1,72 @@
+-- +goose Up
+-- static data export tables, replaced in full by each import
+CREATE TABLE sde_version(
+	id           TINYINT NOT NULL DEFAULT 1, -- single row
+	build_number BIGINT NOT NULL,
+	release_date DATETIME,
+	imported_at  DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE sde_category(
+	category_id INTEGER NOT NULL,
+	name        VARCHAR(128) NOT NULL,
+	published   BOOLEAN NOT NULL,
+	PRIMARY KEY (category_id)
+);
+
+CREATE TABLE sde_group(
+	group_id    INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	name        VARCHAR(128) NOT NULL,
+	published   BOOLEAN NOT NULL,
+	PRIMARY KEY (group_id),
+	INDEX (category_id)
+);
+
+CREATE TABLE sde_market_group(
+	market_group_id INTEGER NOT NULL,
+	parent_group_id INTEGER,
+	name            VARCHAR(128) NOT NULL,
+	has_types       BOOLEAN NOT NULL,
+	PRIMARY KEY (market_group_id),
+	INDEX (parent_group_id)
+);
+
+CREATE TABLE sde_type(
+	type_id         INTEGER NOT NULL,
+	group_id        INTEGER NOT NULL,
+	market_group_id INTEGER,
+	name            VARCHAR(255) NOT NULL,
+	published       BOOLEAN NOT NULL,
+	PRIMARY KEY (type_id),
+	INDEX (group_id),
+	INDEX (name)
+);
+
+CREATE TABLE sde_blueprint_activity(
+	blueprint_type_id INTEGER NOT NULL,
+	activity          VARCHAR(32) NOT NULL,
+	time              INTEGER NOT NULL, -- seconds
+	PRIMARY KEY (blueprint_type_id, activity)
+);
+
+-- materials consumed and products made by each activity
+CREATE TABLE sde_blueprint_material(
+	blueprint_type_id INTEGER NOT NULL,
+	activity          VARCHAR(32) NOT NULL,
+	type_id           INTEGER NOT NULL,
+	quantity          INTEGER NOT NULL,
+	is_product        BOOLEAN NOT NULL,
+	PRIMARY KEY (blueprint_type_id, activity, is_product, type_id),
+	INDEX (type_id)
+);
+
+-- +goose Down
+DROP TABLE sde_blueprint_material;
+DROP TABLE sde_blueprint_activity;
+DROP TABLE sde_type;
+DROP TABLE sde_market_group;
+DROP TABLE sde_group;
+DROP TABLE sde_category;
+DROP TABLE sde_version;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS sde_blueprint(
+	blueprint_type_id    INTEGER NOT NULL,
+	max_production_limit INTEGER NOT NULL, -- most runs a copy can have
+	PRIMARY KEY (blueprint_type_id)
+);
+
+-- version of the importer which loaded the build, see sdeImporterVersion
+ALTER TABLE sde_version ADD COLUMN IF NOT EXISTS importer_version INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE sde_version DROP COLUMN importer_version;
+DROP TABLE sde_blueprint;
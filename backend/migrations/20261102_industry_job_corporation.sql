@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE industry_job ADD COLUMN corporation_id INTEGER NOT NULL DEFAULT 0 AFTER job_id;
+
+-- jobs so far were synced from the admin corp
+UPDATE industry_job
+SET corporation_id = (SELECT JSON_VALUE(config, '$.admin_corp') FROM config LIMIT 1);
+
+-- +goose Down
+ALTER TABLE industry_job DROP COLUMN corporation_id;
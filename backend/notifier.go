@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// alert is a message for the people running the programme
+type alert struct {
+	Kind    string `json:"kind"`
+	Content string `json:"content"` // human readable summary
+	Details any    `json:"details,omitempty"`
+}
+
+// notifier delivers alerts somewhere a human will see them
+type notifier interface {
+	notify(ctx context.Context, a alert) error
+}
+
+// newNotifier returns a notifier posting to webhookUrl, or one which only logs if it's empty
+func newNotifier(logger *zap.Logger, webhookUrl string) notifier {
+	log := &logNotifier{logger: logger.Named("notifier")}
+	if webhookUrl == "" {
+		return log
+	}
+
+	return multiNotifier{log, &webhookNotifier{
+		url:    webhookUrl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}}
+}
+
+type logNotifier struct {
+	logger *zap.Logger
+}
+
+func (n *logNotifier) notify(_ context.Context, a alert) error {
+	n.logger.Warn(a.Content, zap.String("kind", a.Kind), zap.Any("details", a.Details))
+	return nil
+}
+
+// discord rejects messages with more content than this
+const webhookMaxContent = 2000
+
+// webhookPayload is an alert as posted to a webhook. Discord reads content and slack reads text.
+type webhookPayload struct {
+	alert
+	Text string `json:"text"`
+}
+
+// webhookNotifier posts alerts as json, compatible with discord and slack incoming webhooks
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// truncateContent shortens content to at most limit runes, cutting at the end of a line where possible
+func truncateContent(content string, limit int) string {
+	if utf8.RuneCountInString(content) <= limit {
+		return content
+	}
+
+	const more = "\n..."
+	cut := []rune(content)[:limit-utf8.RuneCountInString(more)]
+	out := string(cut)
+	if i := strings.LastIndexByte(out, '\n'); i > 0 {
+		out = out[:i]
+	}
+	return out + more
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, a alert) error {
+	a.Content = truncateContent(a.Content, webhookMaxContent)
+	body, err := json.Marshal(webhookPayload{alert: a, Text: a.Content})
+	if err != nil {
+		return fmt.Errorf("error marshalling alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", esiUserAgent)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// multiNotifier sends alerts to each notifier, returning the first error
+type multiNotifier []notifier
+
+func (m multiNotifier) notify(ctx context.Context, a alert) error {
+	var first error
+	for _, n := range m {
+		if err := n.notify(ctx, a); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pickListLocation is the copies of a line found at a single location
+type pickListLocation struct {
+	itemLocation
+	Quantity int32   `json:"quantity"`
+	ItemIds  []int64 `json:"item_ids"`
+}
+
+type pickListLine struct {
+	Line               int                `json:"line"`
+	TypeId             int32              `json:"type_id"`
+	TypeName           string             `json:"type_name,omitempty"`
+	Runs               int32              `json:"runs"`
+	MaterialEfficiency int32              `json:"me"`
+	TimeEfficiency     int32              `json:"te"`
+	Quantity           int32              `json:"quantity"`              // copies to hand over
+	Unallocated        bool               `json:"unallocated,omitempty"` // any quality, no copies were picked for the line
+	Locations          []pickListLocation `json:"locations"`
+}
+
+type pickList struct {
+	RequisitionId      int64            `json:"requisition_id"`
+	CharacterName      string           `json:"character_name"`
+	Lock               *requisitionLock `json:"lock"`
+	InventoryUpdatedAt time.Time        `json:"inventory_updated_at"`
+	Lines              []pickListLine   `json:"lines"`
+}
+
+// get where each copy requested by a locked requisition can be found. ?format=text returns a printable list.
+func (app *app) getRequisitionPickList(w http.ResponseWriter, r *http.Request) {
+	reqId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid requisition", http.StatusBadRequest)
+		return
+	}
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", reqId))
+
+	req, err := app.dao.getRequisition(reqId)
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "requisition not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Error("error getting requisition", zap.Error(err))
+		httpError(w, "error getting requisition", http.StatusInternalServerError)
+		return
+	}
+
+	lock, err := app.dao.getRequisitionLock(reqId)
+	if err != nil {
+		logger.Error("error getting requisition lock", zap.Error(err))
+		httpError(w, "error getting requisition lock", http.StatusInternalServerError)
+		return
+	}
+	if lock == nil || req.Status != requisitionStatus_Open {
+		httpError(w, "requisition must be open and locked", http.StatusConflict)
+		return
+	}
+
+	app.invStateLock.RLock()
+	list := app.buildPickList(req)
+	app.invStateLock.RUnlock()
+	list.Lock = lock
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set(headerContentType, "text/plain; charset=utf-8")
+		w.Write([]byte(list.String()))
+		return
+	}
+
+	httpWrite(w, list)
+}
+
+// buildPickList finds the inventory copies matching each line of req.
+// Callers must hold app.invStateLock.
+func (app *app) buildPickList(req *requisitionOrder) *pickList {
+	inv := app.inventoryState
+	list := &pickList{
+		RequisitionId:      req.Id,
+		CharacterName:      req.CharacterName,
+		InventoryUpdatedAt: inv.updatedAt,
+		Lines:              []pickListLine{},
+	}
+
+	for i, bp := range req.Blueprints {
+		type need struct {
+			qualities []blueprintQuality // stacks the copies may be taken from
+			quantity  int32
+		}
+		var needs []need
+		unallocated := false
+		switch {
+		case !bp.Any:
+			needs = append(needs, need{[]blueprintQuality{qualityOfRequest(bp)}, bp.count()})
+		case len(bp.Picks) > 0:
+			for _, pick := range bp.Picks {
+				needs = append(needs, need{[]blueprintQuality{qualityOfPick(bp.TypeId, pick)}, pick.Quantity})
+			}
+		default:
+			// allocated before picks were recorded, the requested count may come from any stocked copy
+			unallocated = true
+			n := need{quantity: bp.count()}
+			for _, stack := range inv.bpcs[bp.TypeId] {
+				n.qualities = append(n.qualities, qualityOfBlueprint(stack))
+			}
+			needs = append(needs, n)
+		}
+
+		for _, n := range needs {
+			line := pickListLine{
+				Line:        i,
+				TypeId:      bp.TypeId,
+				TypeName:    cmp.Or(inv.typeNames[bp.TypeId], bp.Name),
+				Quantity:    n.quantity,
+				Unallocated: unallocated,
+				Locations:   []pickListLocation{},
+			}
+			if !unallocated {
+				q := n.qualities[0]
+				line.Runs, line.MaterialEfficiency, line.TimeEfficiency = q.Runs, q.MaterialEfficiency, q.TimeEfficiency
+			}
+
+			locations := map[itemLocation]*pickListLocation{}
+			for _, q := range n.qualities {
+				for _, item := range inv.bpcItems[q] {
+					loc := inv.locate(item.ItemId, item.LocationId, item.LocationFlag)
+					pl, ok := locations[loc]
+					if !ok {
+						pl = &pickListLocation{itemLocation: loc, ItemIds: []int64{}}
+						locations[loc] = pl
+					}
+					pl.Quantity++
+					pl.ItemIds = append(pl.ItemIds, item.ItemId)
+				}
+			}
+
+			for _, pl := range locations {
+				line.Locations = append(line.Locations, *pl)
+			}
+			slices.SortFunc(line.Locations, func(a, b pickListLocation) int {
+				return cmp.Or(
+					cmp.Compare(a.Division, b.Division),
+					cmp.Compare(a.ContainerName, b.ContainerName),
+					cmp.Compare(a.ContainerId, b.ContainerId))
+			})
+
+			list.Lines = append(list.Lines, line)
+		}
+	}
+
+	return list
+}
+
+func (l *pickList) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Requisition #%d for %s", l.RequisitionId, l.CharacterName)
+	if l.Lock != nil {
+		fmt.Fprintf(&sb, ", locked by %s", l.Lock.CharacterName)
+	}
+	fmt.Fprintf(&sb, "\nInventory as of %s\n", l.InventoryUpdatedAt.UTC().Format(time.DateTime))
+
+	for _, line := range l.Lines {
+		if line.Unallocated {
+			fmt.Fprintf(&sb, "\n[ ] %d x %s (any quality, unallocated)\n", line.Quantity, line.TypeName)
+		} else {
+			fmt.Fprintf(&sb, "\n[ ] %d x %s (%d runs, ME %d, TE %d)\n",
+				line.Quantity, line.TypeName, line.Runs, line.MaterialEfficiency, line.TimeEfficiency)
+		}
+		if len(line.Locations) == 0 {
+			sb.WriteString("    not found in inventory\n")
+		}
+		for _, loc := range line.Locations {
+			where := cmp.Or(loc.DivisionName, "unknown division")
+			if loc.ContainerId != 0 {
+				where += " / " + cmp.Or(loc.ContainerName, strconv.FormatInt(loc.ContainerId, 10))
+			}
+			fmt.Fprintf(&sb, "    %s: %d in stock\n", where, loc.Quantity)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+const (
+	plannerDefaultDays = 30
+	maxMaterialEff     = 10
+	maxTimeEff         = 20
+)
+
+// plannerDemand summarises how often a type was requested over the planning period
+type plannerDemand struct {
+	requests int32           // closed requisitions including the type
+	copies   int32           // total copies requested
+	runs     map[int16]int32 // copies requested by runs
+}
+
+// commonRuns is the most requested number of runs, preferring more runs on a tie.
+// Any quality lines don't ask for a number of runs, so 0 is returned if only those were requested.
+func (d *plannerDemand) commonRuns() int16 {
+	var best int16
+	for runs, n := range d.runs {
+		if runs <= 0 {
+			continue
+		}
+		if best == 0 || n > d.runs[best] || (n == d.runs[best] && runs > best) {
+			best = runs
+		}
+	}
+	return best
+}
+
+type plannerBlueprint struct {
+	ItemId             int64 `json:"item_id"`
+	Quantity           int32 `json:"quantity"`
+	MaterialEfficiency int32 `json:"material_efficiency"`
+	TimeEfficiency     int32 `json:"time_efficiency"`
+	LocationId         int64 `json:"location_id"`
+}
+
+type plannerCopyJob struct {
+	TypeId    int32            `json:"type_id"`
+	TypeName  string           `json:"type_name,omitempty"`
+	Copies    int32            `json:"copies"`
+	Runs      int16            `json:"runs"`
+	Reason    string           `json:"reason"`
+	Requested int32            `json:"requested"`   // copies requested by closed requisitions over the period
+	Stock     int32            `json:"stock"`       // copies in the inventory
+	Reserved  int32            `json:"reserved"`    // copies promised to open requisitions
+	InJobs    int32            `json:"in_progress"` // copies being made by active copy jobs
+	Blueprint plannerBlueprint `json:"blueprint"`
+}
+
+type plannerResearchJob struct {
+	TypeId    int32            `json:"type_id"`
+	TypeName  string           `json:"type_name,omitempty"`
+	Activity  string           `json:"activity"`
+	Reason    string           `json:"reason"`
+	Requested int32            `json:"requested"`
+	Blueprint plannerBlueprint `json:"blueprint"`
+}
+
+type plannerResponse struct {
+	PeriodDays  int                  `json:"period_days"`
+	GeneratedAt time.Time            `json:"generated_at"`
+	Copies      []plannerCopyJob     `json:"copies"`
+	Research    []plannerResearchJob `json:"research"`
+}
+
+// getPlanner recommends copy jobs for BPOs whose demand over the period exceeds the unreserved stock,
+// and research jobs for BPOs which are not fully researched. ?days sets the period.
+func (app *app) getPlanner(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	days := plannerDefaultDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		var err error
+		if days, err = strconv.Atoi(d); err != nil || days < 1 || days > 365 {
+			httpError(w, "invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	// open requisitions are counted once, through reserved
+	history, err := app.dao.listClosedRequisitionsSince(now.AddDate(0, 0, -days))
+	if err != nil {
+		logger.Error("error fetching requisition history", zap.Error(err))
+		httpError(w, "error fetching requisition history", http.StatusInternalServerError)
+		return
+	}
+
+	open, err := app.dao.listRequisitionOrders(0, requisitionStatus_Open)
+	if err != nil {
+		logger.Error("error fetching open requisitions", zap.Error(err))
+		httpError(w, "error fetching reservations", http.StatusInternalServerError)
+		return
+	}
+	reserved := newReservations(open)
+
+	jobs, err := app.dao.listActiveIndustryJobs(0, []int32{industryActivity_Copying, industryActivity_ResearchMaterial, industryActivity_ResearchTime})
+	if err != nil {
+		logger.Error("error listing industry jobs", zap.Error(err))
+		httpError(w, "error listing industry jobs", http.StatusInternalServerError)
+		return
+	}
+
+	copying := map[int32]int32{}
+	researching := map[int64]bool{}
+	for _, job := range jobs {
+		switch job.ActivityId {
+		case industryActivity_Copying:
+			copying[job.BlueprintTypeId] += job.Runs
+		default:
+			researching[job.BlueprintId] = true
+		}
+	}
+
+	demand := map[int32]*plannerDemand{}
+	for _, req := range history {
+		seen := map[int32]bool{}
+		for _, bp := range req.Blueprints {
+			d, ok := demand[bp.TypeId]
+			if !ok {
+				d = &plannerDemand{runs: map[int16]int32{}}
+				demand[bp.TypeId] = d
+			}
+			if !seen[bp.TypeId] {
+				d.requests++
+				seen[bp.TypeId] = true
+			}
+			d.copies += bp.count()
+			d.runs[bp.Runs] += bp.count()
+		}
+	}
+
+	maxRuns, err := app.dao.getSdeMaxRuns()
+	if err != nil {
+		logger.Error("error getting blueprint max runs", zap.Error(err))
+		httpError(w, "error getting blueprint max runs", http.StatusInternalServerError)
+		return
+	}
+
+	app.invStateLock.RLock()
+	defer app.invStateLock.RUnlock()
+
+	resp := plannerResponse{
+		PeriodDays:  days,
+		GeneratedAt: now,
+		Copies:      []plannerCopyJob{},
+		Research:    []plannerResearchJob{},
+	}
+
+	for typeId, bpos := range app.inventoryState.bpos {
+		bpo := bestBlueprint(bpos)
+		d, ok := demand[typeId]
+		if !ok {
+			d = &plannerDemand{}
+		}
+
+		var stock int32
+		for _, bpc := range app.inventoryState.bpcs[typeId] {
+			stock += bpc.Quantity
+		}
+
+		// expect demand over the next period to match the last on top of what is already promised,
+		// less copies which are in stock or on the way
+		needed := d.copies + reserved.types[typeId] - stock - copying[typeId]
+		if needed > 0 {
+			// copies which will be handed out as any quality may as well have the most runs
+			runs := cmp.Or(d.commonRuns(), maxRuns[typeId], 1)
+			resp.Copies = append(resp.Copies, plannerCopyJob{
+				TypeId:    typeId,
+				TypeName:  app.inventoryState.typeNames[typeId],
+				Copies:    needed,
+				Runs:      runs,
+				Reason:    fmt.Sprintf("requested %d times in %d days, %d reserved, %d in stock", d.requests, days, reserved.types[typeId], stock),
+				Requested: d.copies,
+				Stock:     stock,
+				Reserved:  reserved.types[typeId],
+				InJobs:    copying[typeId],
+				Blueprint: plannerBlueprintOf(bpo),
+			})
+		}
+
+		if researching[bpo.ItemId] {
+			continue
+		}
+		var activity string
+		switch {
+		case bpo.MaterialEfficiency < maxMaterialEff:
+			activity = industryActivity_name[industryActivity_ResearchMaterial]
+		case bpo.TimeEfficiency < maxTimeEff:
+			activity = industryActivity_name[industryActivity_ResearchTime]
+		default:
+			continue
+		}
+		resp.Research = append(resp.Research, plannerResearchJob{
+			TypeId:    typeId,
+			TypeName:  app.inventoryState.typeNames[typeId],
+			Activity:  activity,
+			Reason:    fmt.Sprintf("ME %d TE %d, requested %d times in %d days", bpo.MaterialEfficiency, bpo.TimeEfficiency, d.requests, days),
+			Requested: d.copies,
+			Blueprint: plannerBlueprintOf(bpo),
+		})
+	}
+
+	slices.SortFunc(resp.Copies, func(a, b plannerCopyJob) int {
+		return cmp.Or(
+			cmp.Compare(b.Copies, a.Copies),
+			cmp.Compare(b.Requested, a.Requested),
+			cmp.Compare(a.TypeId, b.TypeId))
+	})
+	slices.SortFunc(resp.Research, func(a, b plannerResearchJob) int {
+		return cmp.Or(
+			cmp.Compare(b.Requested, a.Requested),
+			cmp.Compare(a.Blueprint.MaterialEfficiency, b.Blueprint.MaterialEfficiency),
+			cmp.Compare(a.TypeId, b.TypeId))
+	})
+
+	httpWrite(w, resp)
+}
+
+// bestBlueprint returns the most researched blueprint of a type
+func bestBlueprint(bps []esi.GetCorporationsCorporationIdBlueprints200Ok) esi.GetCorporationsCorporationIdBlueprints200Ok {
+	return slices.MaxFunc(bps, func(a, b esi.GetCorporationsCorporationIdBlueprints200Ok) int {
+		return cmp.Or(
+			cmp.Compare(a.MaterialEfficiency, b.MaterialEfficiency),
+			cmp.Compare(a.TimeEfficiency, b.TimeEfficiency))
+	})
+}
+
+func plannerBlueprintOf(bp esi.GetCorporationsCorporationIdBlueprints200Ok) plannerBlueprint {
+	return plannerBlueprint{
+		ItemId:             bp.ItemId,
+		Quantity:           max(bp.Quantity, 1),
+		MaterialEfficiency: bp.MaterialEfficiency,
+		TimeEfficiency:     bp.TimeEfficiency,
+		LocationId:         bp.LocationId,
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type requestedBlueprint struct {
 	TypeId             int32  `json:"type_id,omitempty"`
@@ -10,6 +14,108 @@ type requestedBlueprint struct {
 	TimeEfficiency     int8   `json:"te,omitempty"`
 	Quantity           int32  `json:"quantity,omitempty"`
 	Any                bool   `json:"any,omitempty"`
+
+	Picks       []blueprintPick  `json:"picks,omitempty"`       // stock allocated to an any quality line, see validateInventory
+	Fulfillment *lineFulfillment `json:"fulfillment,omitempty"` // set when the requisition is completed
+}
+
+// blueprintPick is a number of copies taken from a single stack in the inventory
+type blueprintPick struct {
+	Runs               int16 `json:"runs"`
+	MaterialEfficiency int8  `json:"me"`
+	TimeEfficiency     int8  `json:"te"`
+	Quantity           int32 `json:"quantity"`
+}
+
+// count returns the number of copies requested. New requisitions are rejected without a quantity,
+// requisitions saved before that were a request for a single copy.
+func (bp requestedBlueprint) count() int32 {
+	return max(bp.Quantity, 1)
+}
+
+// allocated returns the number of copies handed over, or the requested count if the line hasn't been fulfilled yet
+func (bp requestedBlueprint) allocated() int32 {
+	if bp.Fulfillment != nil {
+		return bp.Fulfillment.Quantity
+	}
+	return bp.count()
+}
+
+// lineFulfillment records what was handed over for a single requested line.
+// Quality fields are only set when a substitute was provided.
+type lineFulfillment struct {
+	Quantity           int32  `json:"quantity"`
+	Runs               int16  `json:"runs,omitempty"`
+	MaterialEfficiency int8   `json:"me,omitempty"`
+	TimeEfficiency     int8   `json:"te,omitempty"`
+	Declined           bool   `json:"declined,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+}
+
+// lineFulfillmentRequest sets the fulfillment of the requested line at index Line
+type lineFulfillmentRequest struct {
+	Line int `json:"line"`
+	lineFulfillment
+}
+
+// completeRequisitionRequest is the optional body of a complete action.
+// Lines which are not listed are considered fulfilled in full.
+type completeRequisitionRequest struct {
+	requisitionNotes
+	Lines         []lineFulfillmentRequest `json:"lines,omitempty"`
+	CorporationId int32                    `json:"corporation_id,omitempty"` // source corp which handed over the blueprints
+}
+
+var errNothingFulfilled = errors.New("no blueprints fulfilled, reject the requisition instead")
+
+// fulfill returns a copy of blueprints with the fulfillment of every line set, and the resulting status.
+func fulfill(blueprints []requestedBlueprint, lines []lineFulfillmentRequest) ([]requestedBlueprint, requisitionStatus, error) {
+	out := make([]requestedBlueprint, len(blueprints))
+	for i, bp := range blueprints {
+		bp.Fulfillment = nil // only ever recorded here
+		out[i] = bp
+	}
+
+	for _, line := range lines {
+		if line.Line < 0 || line.Line >= len(out) {
+			return nil, requisitionStatus_Unknown, fmt.Errorf("line %d does not exist", line.Line)
+		}
+		if out[line.Line].Fulfillment != nil {
+			return nil, requisitionStatus_Unknown, fmt.Errorf("line %d fulfilled more than once", line.Line)
+		}
+
+		f := line.lineFulfillment
+		if f.Declined {
+			f.Quantity = 0
+		}
+		if f.Quantity < 0 || f.Quantity > out[line.Line].count() {
+			return nil, requisitionStatus_Unknown, fmt.Errorf("line %d quantity must be between 0 and %d", line.Line, out[line.Line].count())
+		}
+		out[line.Line].Fulfillment = &f
+	}
+
+	var delivered, partial bool
+	for i := range out {
+		if out[i].Fulfillment == nil {
+			out[i].Fulfillment = &lineFulfillment{Quantity: out[i].count()}
+		}
+
+		if out[i].Fulfillment.Quantity > 0 {
+			delivered = true
+		}
+		if out[i].Fulfillment.Quantity < out[i].count() {
+			partial = true
+		}
+	}
+
+	switch {
+	case !delivered:
+		return nil, requisitionStatus_Unknown, errNothingFulfilled
+	case partial:
+		return out, requisitionStatus_PartiallyCompleted, nil
+	default:
+		return out, requisitionStatus_Completed, nil
+	}
 }
 
 type postRequisitionOrderRequest struct {
@@ -26,9 +132,21 @@ type requisitionOrder struct {
 	CharacterName string               `json:"character_name,omitempty"`
 	UpdatedBy     string               `json:"updated_by,omitempty"`
 	PublicNotes   string               `json:"public_notes,omitempty"`
+	PrivateNotes  string               `json:"private_notes,omitempty"` // only visible to workers
+	Version       int32                `json:"version,omitempty"`
+	CorporationId int32                `json:"corporation_id,omitempty"` // source corp which fulfilled the requisition
 	Lock          *requisitionLock     `json:"lock,omitzero"`
 }
 
+// requisitionVersion is a snapshot of the blueprints requested, taken each time the requisition is edited
+type requisitionVersion struct {
+	RequisitionId int64                `json:"requisition_id,omitempty"`
+	Version       int32                `json:"version,omitempty"`
+	Blueprints    []requestedBlueprint `json:"blueprints,omitempty"`
+	CreatedAt     time.Time            `json:"created_at,omitzero"`
+	CreatedBy     string               `json:"created_by,omitempty"`
+}
+
 type requisitionStatus int8
 
 const (
@@ -37,6 +155,7 @@ const (
 	requisitionStatus_Canceled
 	requisitionStatus_Completed
 	requisitionStatus_Rejected
+	requisitionStatus_PartiallyCompleted
 )
 
 var requisitionStauts_name = map[requisitionStatus]string{
@@ -45,8 +164,80 @@ var requisitionStauts_name = map[requisitionStatus]string{
 	requisitionStatus_Canceled:  "closed",
 	requisitionStatus_Completed: "completed",
 	requisitionStatus_Rejected:  "rejected",
+
+	requisitionStatus_PartiallyCompleted: "partially_completed",
 }
 
 func (r requisitionStatus) String() string {
 	return requisitionStauts_name[r]
 }
+
+type requisitionAction string
+
+const (
+	requisitionAction_Create      requisitionAction = "create"
+	requisitionAction_Edit        requisitionAction = "edit"
+	requisitionAction_Lock        requisitionAction = "lock"
+	requisitionAction_Unlock      requisitionAction = "unlock"
+	requisitionAction_ForceUnlock requisitionAction = "force_unlock"
+	requisitionAction_Cancel      requisitionAction = "cancel"
+	requisitionAction_Complete    requisitionAction = "complete"
+	requisitionAction_Reject      requisitionAction = "reject"
+	requisitionAction_Mismatch    requisitionAction = "mismatch" // delivered contract didn't match the requisition
+)
+
+// requisitionEvent is a single entry in the history of a requisition
+type requisitionEvent struct {
+	Id            int64             `json:"id,omitempty"`
+	RequisitionId int64             `json:"requisition_id,omitempty"`
+	Action        requisitionAction `json:"action,omitempty"`
+	CharacterId   int32             `json:"character_id,omitempty"`
+	CharacterName string            `json:"character_name,omitempty"`
+	OldStatus     requisitionStatus `json:"old_status,omitempty"`
+	NewStatus     requisitionStatus `json:"new_status,omitempty"`
+	Notes         string            `json:"notes,omitempty"`
+	PrivateNotes  string            `json:"private_notes,omitempty"` // only visible to workers
+	CreatedAt     time.Time         `json:"created_at,omitzero"`
+}
+
+func newRequisitionEvent(reqId int64, action requisitionAction, actor *user, oldStatus requisitionStatus, newStatus requisitionStatus, notes requisitionNotes) requisitionEvent {
+	return requisitionEvent{
+		RequisitionId: reqId,
+		Action:        action,
+		CharacterId:   actor.CharacterId,
+		CharacterName: actor.CharacterName,
+		OldStatus:     oldStatus,
+		NewStatus:     newStatus,
+		Notes:         notes.Public,
+		PrivateNotes:  notes.Private,
+	}
+}
+
+// requisitionNotes are left by the worker when closing a requisition
+type requisitionNotes struct {
+	Public  string `json:"public_notes,omitempty"`  // visible to the requester
+	Private string `json:"private_notes,omitempty"` // only visible to workers
+}
+
+// requisitionComment is a message in the discussion thread attached to a requisition
+type requisitionComment struct {
+	Id            int64     `json:"id,omitempty"`
+	RequisitionId int64     `json:"requisition_id,omitempty"`
+	ParentId      int64     `json:"parent_id,omitempty"` // comment being replied to
+	CharacterId   int32     `json:"character_id,omitempty"`
+	CharacterName string    `json:"character_name,omitempty"`
+	Body          string    `json:"body,omitempty"`
+	CreatedAt     time.Time `json:"created_at,omitzero"`
+}
+
+// visibleTo reports whether u may see the requisition and its history
+func (req *requisitionOrder) visibleTo(u *user) bool {
+	return u.Level >= authLevel_Worker || u.CharacterId == req.CharacterId
+}
+
+// redactFor removes fields u isn't allowed to see
+func (req *requisitionOrder) redactFor(u *user) {
+	if u.Level < authLevel_Worker {
+		req.PrivateNotes = ""
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+const maxBulkRequisitions = 100
+
+// actionError is a failed requisition action and the http status it should be reported with
+type actionError struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+	Details any    `json:"details,omitempty"`
+}
+
+func (e *actionError) Error() string {
+	return e.Message
+}
+
+func (e *actionError) write(w http.ResponseWriter) {
+	if e.Details != nil {
+		httpErrorDetails(w, e.Message, e.Code, e.Details)
+		return
+	}
+	httpError(w, e.Message, e.Code)
+}
+
+// requisitionUpdateFailure classifies a failed requisition change. errRequisitionChanged means another request
+// modified the requisition or its lock between reading and writing it.
+func requisitionUpdateFailure(logger *zap.Logger, err error, msg string) *actionError {
+	if pErr, ok := errors.AsType[*policyError](err); ok {
+		logger.Debug("requisition refused", zap.Any("violations", pErr.violations))
+		return &actionError{http.StatusUnprocessableEntity, cmp.Or(pErr.message, "requested blueprints are not available"), pErr.violations}
+	}
+	if errors.Is(err, errRequisitionChanged) {
+		logger.Debug("requisition changed during update", zap.Error(err))
+		return &actionError{Code: http.StatusConflict, Message: "requisition was modified by another user"}
+	}
+	logger.Error(msg, zap.Error(err))
+	return &actionError{Code: http.StatusInternalServerError, Message: msg}
+}
+
+// applyRequisitionAction checks the user may perform action on the requisition, then performs it.
+// body is only used by complete and reject.
+func (app *app) applyRequisitionAction(logger *zap.Logger, user *user, reqId int64, action string, body completeRequisitionRequest) *actionError {
+	lock, err := app.dao.getRequisitionLock(reqId)
+	if err != nil {
+		logger.Error("error getting requisition lock", zap.Error(err))
+		return &actionError{Code: http.StatusInternalServerError, Message: "error getting requisition lock"}
+	}
+
+	req, err := app.dao.getRequisition(reqId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &actionError{Code: http.StatusBadRequest, Message: "invalid requisition"}
+		}
+		logger.Error("error getting requisition", zap.Error(err))
+		return &actionError{Code: http.StatusInternalServerError, Message: "error getting requisition"}
+	}
+
+	notOpen := &actionError{Code: http.StatusConflict, Message: "requisition is not open status=" + req.Status.String()}
+
+	switch action {
+	case "lock":
+		if lock != nil {
+			logger.Debug("attempting to lock pre-locked requisition")
+			return &actionError{Code: http.StatusBadRequest, Message: "resource locked"}
+		}
+
+		if req.Status != requisitionStatus_Open {
+			return notOpen
+		}
+
+		ok, err := app.dao.acquireRequisitionLock(reqId, user, requisitionLockExpiry)
+		if err != nil {
+			logger.Error("error locking requisition", zap.Error(err))
+			return &actionError{Code: http.StatusInternalServerError, Message: "error locking requisition"}
+		}
+		if !ok {
+			return &actionError{Code: http.StatusConflict, Message: "resource locked"}
+		}
+
+	case "unlock":
+		if lock == nil {
+			logger.Debug("attempting to unlock requisition that is not locked")
+			return &actionError{Code: http.StatusBadRequest, Message: "resource not locked"}
+		}
+		if lock.CharacterId != user.CharacterId {
+			logger.Debug("attempting to unlock requisition locked by another user", zap.Any("lock", lock))
+			return &actionError{Code: http.StatusForbidden, Message: "can't unlock requisition, locked by " + lock.CharacterName}
+		}
+
+		if _, err := app.dao.releaseRequisitionLock(reqId, user, false); err != nil {
+			logger.Error("error unlocking requisition", zap.Error(err))
+			return &actionError{Code: http.StatusInternalServerError, Message: "error unlocking requisition"}
+		}
+
+	case "cancel":
+		if lock != nil {
+			logger.Debug("attempting to cancel requisition that is locked", zap.Any("lock", lock))
+			return &actionError{Code: http.StatusConflict, Message: "resource is locked"}
+		}
+
+		if user.CharacterId != req.CharacterId {
+			return &actionError{Code: http.StatusUnauthorized, Message: "user/owner mismatch"}
+		}
+
+		if req.Status != requisitionStatus_Open {
+			return notOpen
+		}
+
+		if err = app.dao.cancelRequisition(reqId, user); err != nil {
+			return requisitionUpdateFailure(logger, err, "error cancelling requisition")
+		}
+
+	case "complete", "reject":
+		if lock == nil {
+			logger.Debug("attempting to " + action + " requisition that is not locked")
+			return &actionError{Code: http.StatusConflict, Message: "resource not locked"}
+		}
+		if lock.CharacterId != user.CharacterId {
+			logger.Debug("can't "+action+" requisition, locked by another user", zap.Any("lock", lock))
+			return &actionError{Code: http.StatusForbidden, Message: "can't " + action + " requisition, locked by " + lock.CharacterName}
+		}
+
+		if req.Status != requisitionStatus_Open {
+			return notOpen
+		}
+
+		if action == "complete" {
+			blueprints, status, ferr := fulfill(req.Blueprints, body.Lines)
+			if ferr != nil {
+				return &actionError{Code: http.StatusBadRequest, Message: ferr.Error()}
+			}
+
+			corporationId := body.CorporationId
+			if corps := app.config.sourceCorps(); corporationId == 0 && len(corps) == 1 {
+				corporationId = corps[0].CorporationId
+			} else if corporationId != 0 && !app.config.isSourceCorp(corporationId) {
+				return &actionError{Code: http.StatusBadRequest, Message: "corporation is not a blueprint source"}
+			}
+
+			err = app.dao.completeRequisition(reqId, user, body.requisitionNotes, blueprints, status, corporationId)
+		} else {
+			err = app.dao.rejectRequisition(reqId, user, body.requisitionNotes)
+		}
+		if err != nil {
+			return requisitionUpdateFailure(logger, err, "error updating requisition")
+		}
+
+	default:
+		return &actionError{Code: http.StatusBadRequest, Message: "invalid action"}
+	}
+
+	return nil
+}
+
+type bulkRequisitionRequest struct {
+	Action        string  `json:"action"`
+	Ids           []int64 `json:"ids"`
+	CorporationId int32   `json:"corporation_id,omitempty"` // source corp which handed over completed requisitions
+	requisitionNotes
+}
+
+type bulkRequisitionResult struct {
+	Id    int64        `json:"id"`
+	Ok    bool         `json:"ok"`
+	Error *actionError `json:"error,omitempty"`
+}
+
+// apply lock, unlock, complete or reject to several requisitions. Each id is processed independently,
+// in order, and reported in the response. Completed requisitions are fulfilled in full.
+func (app *app) postBulkRequisitionAction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	var body bulkRequisitionRequest
+	if err := readJsonBody(r, &body); err != nil {
+		httpError(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	switch body.Action {
+	case "lock", "unlock", "complete", "reject":
+	default:
+		httpError(w, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Ids) == 0 || len(body.Ids) > maxBulkRequisitions {
+		httpError(w, "invalid number of requisitions", http.StatusBadRequest)
+		return
+	}
+
+	logger = logger.With(zap.String("action", body.Action))
+	logger.Debug("bulk requisition action", zap.Int64s("ids", body.Ids))
+
+	results := make([]bulkRequisitionResult, len(body.Ids))
+	for i, reqId := range body.Ids {
+		results[i].Id = reqId
+		aErr := app.applyRequisitionAction(logger.With(zap.Int64("id", reqId)), user, reqId, body.Action,
+			completeRequisitionRequest{requisitionNotes: body.requisitionNotes, CorporationId: body.CorporationId})
+		results[i].Ok = aErr == nil
+		results[i].Error = aErr
+	}
+
+	httpWrite(w, results)
+}
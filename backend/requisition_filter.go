@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerNextCursor = "X-Next-Cursor"
+
+	requisitionPageDefault = 100
+	requisitionPageMax     = 500
+)
+
+// requisitionSort is a column requisitions can be ordered by, prefixed with - for descending
+type requisitionSort string
+
+const (
+	requisitionSort_CreatedAsc  requisitionSort = "created_at" // queue order
+	requisitionSort_CreatedDesc requisitionSort = "-created_at"
+	requisitionSort_UpdatedAsc  requisitionSort = "updated_at"
+	requisitionSort_UpdatedDesc requisitionSort = "-updated_at"
+)
+
+func (s requisitionSort) column() string {
+	return strings.TrimPrefix(string(s), "-")
+}
+
+func (s requisitionSort) descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// requisitionCursor is the position of the last requisition on the previous page
+type requisitionCursor struct {
+	sort requisitionSort
+	at   time.Time
+	id   int64
+}
+
+func newRequisitionCursor(sort requisitionSort, req requisitionOrder) requisitionCursor {
+	at := req.CreatedAt
+	if sort.column() == "updated_at" {
+		at = req.UpdatedAt
+	}
+	return requisitionCursor{sort: sort, at: at, id: req.Id}
+}
+
+func (c requisitionCursor) String() string {
+	raw := fmt.Sprintf("%s|%d|%d", c.sort, c.at.Unix(), c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseRequisitionCursor(s string) (*requisitionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed cursor")
+	}
+
+	at, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &requisitionCursor{sort: requisitionSort(parts[0]), at: time.Unix(at, 0).UTC(), id: id}, nil
+}
+
+// requisitionFilter selects a page of requisitions, see dao.queryRequisitionOrders.
+// Zero value fields don't filter.
+type requisitionFilter struct {
+	Statuses    []requisitionStatus
+	CharacterId int32
+	UpdatedBy   string
+	TypeId      int32
+	LockedBy    int32
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        requisitionSort
+	Limit       int
+	Cursor      *requisitionCursor
+}
+
+// parseRequisitionFilter reads a filter from the query string.
+// Statuses may be repeated or comma separated, as ids or names. "all" or 0 disables the status filter,
+// which otherwise defaults to open requisitions.
+func parseRequisitionFilter(query url.Values) (*requisitionFilter, error) {
+	f := &requisitionFilter{
+		Sort:  requisitionSort_CreatedAsc,
+		Limit: requisitionPageDefault,
+	}
+
+	var allStatuses bool
+	for _, param := range query["status"] {
+		for s := range strings.SplitSeq(param, ",") {
+			status, err := parseRequisitionStatus(s)
+			if err != nil {
+				return nil, err
+			}
+			if status == requisitionStatus_Unknown {
+				allStatuses = true
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	switch {
+	case allStatuses:
+		f.Statuses = nil
+	case len(f.Statuses) == 0:
+		f.Statuses = []requisitionStatus{requisitionStatus_Open}
+	}
+
+	var err error
+	if f.CharacterId, err = parseInt32Param(query, "character_id"); err != nil {
+		return nil, err
+	}
+	if f.TypeId, err = parseInt32Param(query, "type_id"); err != nil {
+		return nil, err
+	}
+	if f.LockedBy, err = parseInt32Param(query, "locked_by"); err != nil {
+		return nil, err
+	}
+	f.UpdatedBy = query.Get("updated_by")
+
+	if s := query.Get("created_from"); s != "" {
+		if f.CreatedFrom, err = parseTimeParam(s, time.Time{}); err != nil {
+			return nil, fmt.Errorf("invalid created_from: %w", err)
+		}
+	}
+	if s := query.Get("created_to"); s != "" {
+		if f.CreatedTo, err = parseTimeParam(s, time.Time{}); err != nil {
+			return nil, fmt.Errorf("invalid created_to: %w", err)
+		}
+	}
+
+	if s := query.Get("sort"); s != "" {
+		switch f.Sort = requisitionSort(s); f.Sort {
+		case requisitionSort_CreatedAsc, requisitionSort_CreatedDesc, requisitionSort_UpdatedAsc, requisitionSort_UpdatedDesc:
+		default:
+			return nil, fmt.Errorf("invalid sort %q", s)
+		}
+	}
+
+	if s := query.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 1 || f.Limit > requisitionPageMax {
+			return nil, fmt.Errorf("limit must be between 1 and %d", requisitionPageMax)
+		}
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		if f.Cursor, err = parseRequisitionCursor(s); err != nil || f.Cursor.sort != f.Sort {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	return f, nil
+}
+
+func parseRequisitionStatus(s string) (requisitionStatus, error) {
+	s = strings.TrimSpace(s)
+	if s == "all" {
+		return requisitionStatus_Unknown, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 8); err == nil {
+		if _, ok := requisitionStauts_name[requisitionStatus(i)]; ok {
+			return requisitionStatus(i), nil
+		}
+	}
+	for status, name := range requisitionStauts_name {
+		if name == s {
+			return status, nil
+		}
+	}
+	return requisitionStatus_Unknown, fmt.Errorf("invalid status %q", s)
+}
+
+func parseInt32Param(query url.Values, key string) (int32, error) {
+	s := query.Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", key)
+	}
+	return int32(i), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	policyRule_MaxBlueprints = "max_blueprints"
+	policyRule_MaxOpen       = "max_open_requisitions"
+	policyRule_CapitalLimit  = "capital_limit"
+)
+
+// policyViolation describes a single reason a requisition was refused
+type policyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	TypeId  int32  `json:"type_id,omitempty"`
+}
+
+// policyError is returned when a requisition breaks one or more rules
+type policyError struct {
+	message    string // defaults to the blueprints not being available
+	violations []policyViolation
+}
+
+func (e *policyError) Error() string {
+	return fmt.Sprintf("requisition violates %d rule(s)", len(e.violations))
+}
+
+// policyInput is everything a policyRule needs to evaluate a requisition
+type policyInput struct {
+	config     *appConfig
+	now        time.Time
+	blueprints []requestedBlueprint
+	history    []requisitionOrder // requisitions owned by the same account, see lockAccountRequisitions
+}
+
+// policyRule returns any violations of a single programme rule.
+// A rule with no configured limit (zero value) is disabled.
+type policyRule func(in *policyInput) []policyViolation
+
+var requisitionPolicy = []policyRule{
+	policyMaxBlueprints,
+	policyMaxOpen,
+	policyCapitalLimit,
+}
+
+// policyCheck returns a check for dao.createRequisition and dao.editRequisition which evaluates the programme
+// rules and then the inventory. It runs while the account and its requisitions are locked, so two requisitions
+// from one account can't both pass the limits.
+// Callers must hold app.invStateLock until the dao call returns.
+func (app *app) policyCheck(user *user, blueprints []requestedBlueprint, excludeId int64) requisitionCheck {
+	inventory := app.inventoryCheck(blueprints, excludeId)
+	return func(q querier, open []requisitionOrder) error {
+		violations, err := app.evaluateRequisitionPolicy(q, user, blueprints, excludeId)
+		if err != nil {
+			return fmt.Errorf("error evaluating requisition policy: %w", err)
+		}
+		if len(violations) > 0 {
+			return &policyError{message: "requisition violates programme rules", violations: violations}
+		}
+		return inventory(q, open)
+	}
+}
+
+// evaluateRequisitionPolicy checks blueprints against the programme rules for the user's account.
+// excludeId is a requisition being replaced by blueprints, which shouldn't count against the limits.
+// The account's requisitions are read with q, within the transaction saving the requisition.
+func (app *app) evaluateRequisitionPolicy(q querier, user *user, blueprints []requestedBlueprint, excludeId int64) ([]policyViolation, error) {
+	in := &policyInput{
+		config:     app.config,
+		now:        time.Now(),
+		blueprints: blueprints,
+	}
+
+	since := in.now.AddDate(0, 0, -int(in.config.CapitalPeriodDays))
+	history, err := lockAccountRequisitions(q, user.UserId, user.CharacterId, since)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching account requisitions: %w", err)
+	}
+	in.history = slices.DeleteFunc(history, func(req requisitionOrder) bool {
+		return req.Id == excludeId
+	})
+
+	var violations []policyViolation
+	for _, rule := range requisitionPolicy {
+		violations = append(violations, rule(in)...)
+	}
+
+	return violations, nil
+}
+
+func policyMaxBlueprints(in *policyInput) []policyViolation {
+	if in.config.MaxBlueprints <= 0 {
+		return nil
+	}
+
+	var total int32
+	for _, bp := range in.blueprints {
+		total += bp.count()
+	}
+
+	if total <= in.config.MaxBlueprints {
+		return nil
+	}
+
+	return []policyViolation{{
+		Rule:    policyRule_MaxBlueprints,
+		Message: fmt.Sprintf("requested %d blueprints, the limit is %d per requisition", total, in.config.MaxBlueprints),
+	}}
+}
+
+func policyMaxOpen(in *policyInput) []policyViolation {
+	if in.config.MaxContracts <= 0 {
+		return nil
+	}
+
+	var open int32
+	for _, req := range in.history {
+		if req.Status == requisitionStatus_Open {
+			open++
+		}
+	}
+
+	if open < in.config.MaxContracts {
+		return nil
+	}
+
+	return []policyViolation{{
+		Rule:    policyRule_MaxOpen,
+		Message: fmt.Sprintf("%d open requisition(s) on this account, the limit is %d per person", open, in.config.MaxContracts),
+	}}
+}
+
+func policyCapitalLimit(in *policyInput) []policyViolation {
+	if in.config.CapitalLimit <= 0 || len(in.config.CapitalBlueprints) == 0 {
+		return nil
+	}
+
+	var used int32
+	for _, req := range in.history {
+		switch req.Status {
+		case requisitionStatus_Canceled, requisitionStatus_Rejected:
+			continue
+		}
+		if in.config.CapitalPeriodDays > 0 && req.CreatedAt.Before(in.now.AddDate(0, 0, -int(in.config.CapitalPeriodDays))) {
+			continue
+		}
+
+		for _, bp := range req.Blueprints {
+			if slices.Contains(in.config.CapitalBlueprints, bp.TypeId) {
+				used += bp.allocated()
+			}
+		}
+	}
+
+	var violations []policyViolation
+	for _, bp := range in.blueprints {
+		if !slices.Contains(in.config.CapitalBlueprints, bp.TypeId) {
+			continue
+		}
+
+		used += bp.count()
+		if used > in.config.CapitalLimit {
+			violations = append(violations, policyViolation{
+				Rule:    policyRule_CapitalLimit,
+				Message: fmt.Sprintf("capital blueprints are limited to %d per %d days", in.config.CapitalLimit, in.config.CapitalPeriodDays),
+				TypeId:  bp.TypeId,
+			})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/antihax/goesi/esi"
+)
+
+const (
+	policyRule_NotStocked         = "not_stocked"
+	policyRule_QualityUnavailable = "quality_unavailable"
+	policyRule_InsufficientStock  = "insufficient_stock"
+)
+
+// allocation strategies for any quality lines, set with appConfig.AllocationStrategy
+const (
+	allocationStrategy_BestQuality = "best_quality" // highest ME, TE then runs first
+	allocationStrategy_LowestRuns  = "lowest_runs"  // use up copies with the fewest runs first
+)
+
+// allocationOrder sorts inventory stacks into the order they should be picked from
+var allocationOrder = map[string]func(a, b esi.GetCorporationsCorporationIdBlueprints200Ok) int{
+	allocationStrategy_BestQuality: func(a, b esi.GetCorporationsCorporationIdBlueprints200Ok) int {
+		return cmp.Or(
+			cmp.Compare(b.MaterialEfficiency, a.MaterialEfficiency),
+			cmp.Compare(b.TimeEfficiency, a.TimeEfficiency),
+			cmp.Compare(b.Runs, a.Runs))
+	},
+	allocationStrategy_LowestRuns: func(a, b esi.GetCorporationsCorporationIdBlueprints200Ok) int {
+		return cmp.Or(
+			cmp.Compare(a.Runs, b.Runs),
+			cmp.Compare(b.MaterialEfficiency, a.MaterialEfficiency),
+			cmp.Compare(b.TimeEfficiency, a.TimeEfficiency))
+	},
+}
+
+// blueprintQuality identifies a stack of equivalent blueprint copies
+type blueprintQuality struct {
+	TypeId             int32
+	Runs               int32
+	MaterialEfficiency int32
+	TimeEfficiency     int32
+}
+
+func qualityOfRequest(bp requestedBlueprint) blueprintQuality {
+	return blueprintQuality{
+		TypeId:             bp.TypeId,
+		Runs:               int32(bp.Runs),
+		MaterialEfficiency: int32(bp.MaterialEfficiency),
+		TimeEfficiency:     int32(bp.TimeEfficiency),
+	}
+}
+
+func qualityOfPick(typeId int32, p blueprintPick) blueprintQuality {
+	return blueprintQuality{
+		TypeId:             typeId,
+		Runs:               int32(p.Runs),
+		MaterialEfficiency: int32(p.MaterialEfficiency),
+		TimeEfficiency:     int32(p.TimeEfficiency),
+	}
+}
+
+func qualityOfBlueprint(bp esi.GetCorporationsCorporationIdBlueprints200Ok) blueprintQuality {
+	return blueprintQuality{
+		TypeId:             bp.TypeId,
+		Runs:               bp.Runs,
+		MaterialEfficiency: bp.MaterialEfficiency,
+		TimeEfficiency:     bp.TimeEfficiency,
+	}
+}
+
+// reservations are the copies promised to open requisitions.
+// lines requesting any quality reserve their picks, or only the type total if they have none.
+type reservations struct {
+	quality map[blueprintQuality]int32
+	types   map[int32]int32 // total reserved per type, including any quality
+}
+
+func newReservations(open []requisitionOrder) *reservations {
+	r := &reservations{
+		quality: map[blueprintQuality]int32{},
+		types:   map[int32]int32{},
+	}
+	for _, req := range open {
+		if req.Status != requisitionStatus_Open {
+			continue
+		}
+		r.add(req.Blueprints)
+	}
+	return r
+}
+
+func (r *reservations) add(blueprints []requestedBlueprint) {
+	for _, bp := range blueprints {
+		if !bp.Any {
+			r.quality[qualityOfRequest(bp)] += bp.count()
+		} else {
+			for _, pick := range bp.Picks {
+				r.quality[qualityOfPick(bp.TypeId, pick)] += pick.Quantity
+			}
+		}
+		r.types[bp.TypeId] += bp.count()
+	}
+}
+
+// inventoryCheck returns a check for dao.createRequisition and dao.editRequisition which validates
+// blueprints against the inventory. excludeId is a requisition being replaced by blueprints.
+// Callers must hold app.invStateLock until the dao call returns.
+func (app *app) inventoryCheck(blueprints []requestedBlueprint, excludeId int64) requisitionCheck {
+	return func(_ querier, open []requisitionOrder) error {
+		open = slices.DeleteFunc(open, func(req requisitionOrder) bool {
+			return req.Id == excludeId
+		})
+		if violations := app.validateInventory(blueprints, newReservations(open)); len(violations) > 0 {
+			return &policyError{violations: violations}
+		}
+		return nil
+	}
+}
+
+// validateInventory checks each requested line against the inventory snapshot minus the
+// copies already reserved. Any quality lines are allocated picks from the inventory.
+// Valid lines are added to reserved.
+// Callers must hold app.invStateLock.
+func (app *app) validateInventory(blueprints []requestedBlueprint, reserved *reservations) []policyViolation {
+	var violations []policyViolation
+	for i := range blueprints {
+		bp := &blueprints[i]
+		bp.Picks = nil
+
+		stacks, ok := app.inventoryState.bpcs[bp.TypeId]
+		if !ok {
+			violations = append(violations, policyViolation{
+				Rule:    policyRule_NotStocked,
+				Message: fmt.Sprintf("type %d is not stocked", bp.TypeId),
+				TypeId:  bp.TypeId,
+			})
+			continue
+		}
+
+		var typeStock, qualityStock int32
+		for _, stack := range stacks {
+			typeStock += stack.Quantity
+			if !bp.Any && qualityOfBlueprint(stack) == qualityOfRequest(*bp) {
+				qualityStock += stack.Quantity
+			}
+		}
+
+		available := typeStock - reserved.types[bp.TypeId]
+		if !bp.Any {
+			if qualityStock == 0 {
+				violations = append(violations, policyViolation{
+					Rule: policyRule_QualityUnavailable,
+					Message: fmt.Sprintf("%s is not stocked with %d runs ME %d TE %d",
+						app.inventoryState.typeNames[bp.TypeId], bp.Runs, bp.MaterialEfficiency, bp.TimeEfficiency),
+					TypeId: bp.TypeId,
+				})
+				continue
+			}
+			available = min(available, qualityStock-reserved.quality[qualityOfRequest(*bp)])
+		}
+
+		if bp.count() > available {
+			violations = append(violations, policyViolation{
+				Rule: policyRule_InsufficientStock,
+				Message: fmt.Sprintf("requested %d %s, %d available",
+					bp.count(), app.inventoryState.typeNames[bp.TypeId], max(available, 0)),
+				TypeId: bp.TypeId,
+			})
+			continue
+		}
+
+		if bp.Any {
+			if bp.Picks = app.allocate(stacks, bp.count(), reserved); bp.Picks == nil {
+				violations = append(violations, policyViolation{
+					Rule:    policyRule_InsufficientStock,
+					Message: fmt.Sprintf("requested %d %s, copies are promised to other requisitions", bp.count(), app.inventoryState.typeNames[bp.TypeId]),
+					TypeId:  bp.TypeId,
+				})
+				continue
+			}
+		}
+
+		reserved.add([]requestedBlueprint{*bp})
+	}
+
+	return violations
+}
+
+// allocate picks count copies from stacks using the configured strategy, skipping copies already reserved.
+// Returns nil if there aren't enough free copies.
+func (app *app) allocate(stacks []esi.GetCorporationsCorporationIdBlueprints200Ok, count int32, reserved *reservations) []blueprintPick {
+	order, ok := allocationOrder[app.config.AllocationStrategy]
+	if !ok {
+		order = allocationOrder[allocationStrategy_BestQuality]
+	}
+
+	var picks []blueprintPick
+	for _, stack := range slices.SortedStableFunc(slices.Values(stacks), order) {
+		if count == 0 {
+			break
+		}
+
+		free := stack.Quantity - reserved.quality[qualityOfBlueprint(stack)]
+		if free <= 0 {
+			continue
+		}
+
+		n := min(free, count)
+		picks = append(picks, blueprintPick{
+			Runs:               int16(stack.Runs),
+			MaterialEfficiency: int8(stack.MaterialEfficiency),
+			TimeEfficiency:     int8(stack.TimeEfficiency),
+			Quantity:           n,
+		})
+		count -= n
+	}
+
+	if count > 0 {
+		return nil
+	}
+	return picks
+}
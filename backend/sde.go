@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AlHeamer/brave-bpc/glue"
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+const maxNameIds = 1000
+
+// sdeImporterVersion is bumped whenever the importer starts loading more of the dump, so a build which is
+// already loaded is imported again to fill the new tables
+const sdeImporterVersion = 2
+
+// sde jsonl files, see https://developers.eveonline.com/docs/services/static-data/
+const (
+	sdeFile_Info         = "_sde.jsonl"
+	sdeFile_Categories   = "categories.jsonl"
+	sdeFile_Groups       = "groups.jsonl"
+	sdeFile_MarketGroups = "marketGroups.jsonl"
+	sdeFile_Types        = "types.jsonl"
+	sdeFile_Blueprints   = "blueprints.jsonl"
+)
+
+// sdeName is a localised name, keyed by language
+type sdeName map[string]string
+
+func (n sdeName) String() string {
+	return n["en"]
+}
+
+type sdeInfo struct {
+	Key         string    `json:"_key"`
+	BuildNumber int64     `json:"buildNumber"`
+	ReleaseDate time.Time `json:"releaseDate"`
+}
+
+type sdeCategory struct {
+	Key       int32   `json:"_key"`
+	Name      sdeName `json:"name"`
+	Published bool    `json:"published"`
+}
+
+type sdeGroup struct {
+	Key        int32   `json:"_key"`
+	CategoryId int32   `json:"categoryID"`
+	Name       sdeName `json:"name"`
+	Published  bool    `json:"published"`
+}
+
+type sdeMarketGroup struct {
+	Key           int32   `json:"_key"`
+	ParentGroupId *int32  `json:"parentGroupID"`
+	Name          sdeName `json:"name"`
+	HasTypes      bool    `json:"hasTypes"`
+}
+
+type sdeType struct {
+	Key           int32   `json:"_key"`
+	GroupId       int32   `json:"groupID"`
+	MarketGroupId *int32  `json:"marketGroupID"`
+	Name          sdeName `json:"name"`
+	Published     bool    `json:"published"`
+}
+
+type sdeQuantity struct {
+	TypeId   int32 `json:"typeID"`
+	Quantity int32 `json:"quantity"`
+}
+
+type sdeActivity struct {
+	Time      int32         `json:"time"`
+	Materials []sdeQuantity `json:"materials"`
+	Products  []sdeQuantity `json:"products"`
+}
+
+type sdeBlueprint struct {
+	Key                int32                  `json:"_key"`
+	MaxProductionLimit int32                  `json:"maxProductionLimit"`
+	Activities         map[string]sdeActivity `json:"activities"` // copying, manufacturing, research_material...
+}
+
+// sdeData is everything imported from a single sde dump
+type sdeData struct {
+	Info         sdeInfo
+	Categories   []sdeCategory
+	Groups       []sdeGroup
+	MarketGroups []sdeMarketGroup
+	Types        []sdeType
+	Blueprints   []sdeBlueprint
+}
+
+// sdeVersion is the dump currently loaded into the sde tables
+type sdeVersion struct {
+	BuildNumber     int64     `json:"build_number"`
+	ImporterVersion int32     `json:"importer_version"`
+	ReleaseDate     time.Time `json:"release_date,omitzero"`
+	ImportedAt      time.Time `json:"imported_at"`
+}
+
+// universeName matches esi's universe/names response so clients can use either
+type universeName struct {
+	Category string `json:"category"`
+	Id       int32  `json:"id"`
+	Name     string `json:"name"`
+}
+
+// readJsonl decodes every line of a jsonl file in dir
+func readJsonl[T any](dir string, file string) ([]T, error) {
+	fp, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var out []T
+	dec := json.NewDecoder(fp)
+	for {
+		var v T
+		if err = dec.Decode(&v); errors.Is(err, io.EOF) {
+			return out, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", file, err)
+		}
+		out = append(out, v)
+	}
+}
+
+// loadSde reads the rest of the dump described by info
+func loadSde(dir string, info sdeInfo) (*sdeData, error) {
+	var err error
+	data := &sdeData{Info: info}
+	if data.Categories, err = readJsonl[sdeCategory](dir, sdeFile_Categories); err != nil {
+		return nil, err
+	}
+	if data.Groups, err = readJsonl[sdeGroup](dir, sdeFile_Groups); err != nil {
+		return nil, err
+	}
+	if data.MarketGroups, err = readJsonl[sdeMarketGroup](dir, sdeFile_MarketGroups); err != nil {
+		return nil, err
+	}
+	if data.Types, err = readJsonl[sdeType](dir, sdeFile_Types); err != nil {
+		return nil, err
+	}
+	if data.Blueprints, err = readJsonl[sdeBlueprint](dir, sdeFile_Blueprints); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// importSde loads the sde jsonl dump in dir into the database, unless that build is already loaded by this importer
+func (app *app) importSde(logger *zap.Logger, dir string) error {
+	start := time.Now()
+	info, err := readJsonl[sdeInfo](dir, sdeFile_Info)
+	if err != nil {
+		return err
+	}
+	if len(info) == 0 {
+		return fmt.Errorf("%s is empty", sdeFile_Info)
+	}
+
+	current, err := app.dao.getSdeVersion()
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error getting sde version: %w", err)
+	}
+	if current != nil && current.BuildNumber == info[0].BuildNumber && current.ImporterVersion == sdeImporterVersion {
+		logger.Info("sde is up to date", zap.Int64("build_number", current.BuildNumber))
+		return nil
+	}
+
+	data, err := loadSde(dir, info[0])
+	if err != nil {
+		return err
+	}
+	if err = app.dao.replaceSde(data); err != nil {
+		return err
+	}
+
+	logger.Info("imported sde",
+		zap.Int64("build_number", data.Info.BuildNumber),
+		zap.Int("types", len(data.Types)),
+		zap.Int("blueprints", len(data.Blueprints)),
+		zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// resolveTypeNames looks up type names in the sde, asking esi for any it doesn't know
+func (app *app) resolveTypeNames(ctx context.Context, logger *zap.Logger, typeIds []int32) map[int32]string {
+	names, err := app.dao.getSdeTypeNames(typeIds)
+	if err != nil {
+		logger.Error("error getting sde type names", zap.Error(err))
+		names = map[int32]string{}
+	}
+
+	var missing []int32
+	for _, id := range typeIds {
+		if _, ok := names[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		logger.Debug("type names missing from sde", zap.Int("types", len(missing)))
+		for id, name := range app.fetchTypeNames(ctx, logger, glue.NameCategory_InventoryType, missing) {
+			names[id] = name
+		}
+	}
+
+	return names
+}
+
+// get the version of the loaded sde
+func (app *app) getSde(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	version, err := app.dao.getSdeVersion()
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "sde not imported", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Error("error getting sde version", zap.Error(err))
+		httpError(w, "error getting sde version", http.StatusInternalServerError)
+		return
+	}
+
+	httpWrite(w, version)
+}
+
+// resolve names of ids, a drop in for esi's POST universe/names.
+// Types come from the sde, everything else such as characters and stations is asked of esi.
+func (app *app) postNames(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	var ids []int32
+	if err := readJsonBody(r, &ids); err != nil {
+		httpError(w, "malformed ids", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxNameIds {
+		httpError(w, fmt.Sprintf("at most %d ids", maxNameIds), http.StatusBadRequest)
+		return
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	types, err := app.dao.getSdeTypeNames(ids)
+	if err != nil {
+		logger.Error("error getting sde type names", zap.Error(err))
+		types = map[int32]string{}
+	}
+
+	names := []universeName{}
+	var missing []int32
+	for _, id := range ids {
+		if name, ok := types[id]; ok {
+			names = append(names, universeName{Category: string(glue.NameCategory_InventoryType), Id: id, Name: name})
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		esiNames, _, err := esiCall(r.Context(), logger, func(ctx context.Context) ([]esi.PostUniverseNames200Ok, *http.Response, error) {
+			return app.esi.ESI.UniverseApi.PostUniverseNames(ctx, missing, nil)
+		})
+		if err != nil {
+			// the names which were found are still useful
+			logger.Warn("error fetching names", zap.Int("ids", len(missing)), zap.Error(err))
+		}
+		for _, v := range esiNames {
+			names = append(names, universeName{Category: v.Category, Id: v.Id, Name: v.Name})
+		}
+	}
+
+	slices.SortFunc(names, func(a, b universeName) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	httpWrite(w, names)
+}
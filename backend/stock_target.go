@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const alertKind_LowStock = "low_stock"
+
+// stockTarget is the number of copies of a type which should be kept on the shelf.
+// Copies count towards the target if they meet the minimum quality bracket.
+type stockTarget struct {
+	Id                    int64     `json:"id,omitempty"`
+	TypeId                int32     `json:"type_id"`
+	MinRuns               int32     `json:"min_runs"`
+	MinMaterialEfficiency int32     `json:"min_me"`
+	MinTimeEfficiency     int32     `json:"min_te"`
+	Target                int32     `json:"target"`
+	UpdatedAt             time.Time `json:"updated_at,omitzero"`
+	UpdatedBy             string    `json:"updated_by,omitempty"`
+}
+
+func (t *stockTarget) matches(q blueprintQuality) bool {
+	return q.TypeId == t.TypeId &&
+		q.Runs >= t.MinRuns &&
+		q.MaterialEfficiency >= t.MinMaterialEfficiency &&
+		q.TimeEfficiency >= t.MinTimeEfficiency
+}
+
+type stockShortfall struct {
+	stockTarget
+	TypeName  string `json:"type_name,omitempty"`
+	Stock     int32  `json:"stock"`
+	Shortfall int32  `json:"shortfall"`
+}
+
+// stockShortfalls compares targets against the inventory snapshot, returning the targets which aren't met.
+// Callers must hold app.invStateLock.
+func (app *app) stockShortfalls(targets []stockTarget) []stockShortfall {
+	shortfalls := []stockShortfall{}
+	for _, target := range targets {
+		var stock int32
+		for _, bpc := range app.inventoryState.bpcs[target.TypeId] {
+			if target.matches(qualityOfBlueprint(bpc)) {
+				stock += bpc.Quantity
+			}
+		}
+
+		if stock >= target.Target {
+			continue
+		}
+
+		shortfalls = append(shortfalls, stockShortfall{
+			stockTarget: target,
+			TypeName:    app.inventoryState.typeNames[target.TypeId],
+			Stock:       stock,
+			Shortfall:   target.Target - stock,
+		})
+	}
+
+	slices.SortFunc(shortfalls, func(a, b stockShortfall) int {
+		return cmp.Or(
+			cmp.Compare(b.Shortfall, a.Shortfall),
+			cmp.Compare(a.TypeId, b.TypeId))
+	})
+	return shortfalls
+}
+
+// checkStockTargets alerts on targets which have fallen short since the last check
+func (app *app) checkStockTargets(ctx context.Context, logger *zap.Logger) error {
+	targets, err := app.dao.listStockTargets()
+	if err != nil {
+		return fmt.Errorf("error listing stock targets: %w", err)
+	}
+
+	app.invStateLock.RLock()
+	shortfalls := app.stockShortfalls(targets)
+	app.invStateLock.RUnlock()
+
+	var fresh []stockShortfall
+	alerted := make(map[int64]bool, len(shortfalls))
+	for _, s := range shortfalls {
+		alerted[s.Id] = true
+		if !app.stockAlerted[s.Id] {
+			fresh = append(fresh, s)
+		}
+	}
+	if len(fresh) == 0 {
+		app.stockAlerted = alerted
+		return nil
+	}
+
+	lines := make([]string, len(fresh))
+	for i, s := range fresh {
+		name := s.TypeName
+		if name == "" {
+			name = strconv.Itoa(int(s.TypeId))
+		}
+		lines[i] = fmt.Sprintf("%s (%d+ runs, ME %d+, TE %d+): %d of %d in stock",
+			name, s.MinRuns, s.MinMaterialEfficiency, s.MinTimeEfficiency, s.Stock, s.Target)
+	}
+
+	logger.Debug("stock below target", zap.Int("targets", len(fresh)))
+	err = app.notifier.notify(ctx, alert{
+		Kind:    alertKind_LowStock,
+		Content: "Blueprint copies below stock target:\n" + strings.Join(lines, "\n"),
+		Details: fresh,
+	})
+	if err != nil {
+		// alert on these again next check
+		for _, s := range fresh {
+			delete(alerted, s.Id)
+		}
+	}
+	app.stockAlerted = alerted
+	return err
+}
+
+func (app *app) listStockTargets(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	targets, err := app.dao.listStockTargets()
+	if err != nil {
+		logger.Error("error listing stock targets", zap.Error(err))
+		httpError(w, "error listing stock targets", http.StatusInternalServerError)
+		return
+	}
+
+	httpWrite(w, targets)
+}
+
+// create or replace the target for a type and quality bracket
+func (app *app) putStockTarget(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	user := app.getUserFromSession(r)
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	var target stockTarget
+	if err := readJsonBody(r, &target); err != nil {
+		httpError(w, "malformed stock target", http.StatusBadRequest)
+		return
+	}
+
+	if target.TypeId <= 0 ||
+		target.Target < 0 ||
+		target.MinRuns < 0 ||
+		target.MinMaterialEfficiency < 0 || target.MinMaterialEfficiency > maxMaterialEff ||
+		target.MinTimeEfficiency < 0 || target.MinTimeEfficiency > maxTimeEff {
+		httpError(w, "invalid stock target", http.StatusBadRequest)
+		return
+	}
+	target.UpdatedBy = user.CharacterName
+
+	id, err := app.dao.upsertStockTarget(target)
+	if err != nil {
+		logger.Error("error saving stock target", zap.Error(err))
+		httpError(w, "error saving stock target", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("stock target updated", zap.String("updated_by", user.CharacterName), zap.Any("target", target))
+	httpWrite(w, map[string]int64{"id": id})
+}
+
+func (app *app) deleteStockTarget(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromSession(r)
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid stock target", http.StatusBadRequest)
+		return
+	}
+	logger := getLoggerFromContext(r.Context()).Named("api").With(zap.Int64("id", id))
+
+	if err = app.dao.deleteStockTarget(id); errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "stock target not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Error("error deleting stock target", zap.Error(err))
+		httpError(w, "error deleting stock target", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("stock target deleted", zap.String("deleted_by", user.CharacterName))
+	httpWrite(w, struct{}{})
+}
+
+// report the targets which the current inventory doesn't meet
+func (app *app) getStockShortfall(w http.ResponseWriter, r *http.Request) {
+	logger := getLoggerFromContext(r.Context()).Named("api")
+
+	targets, err := app.dao.listStockTargets()
+	if err != nil {
+		logger.Error("error listing stock targets", zap.Error(err))
+		httpError(w, "error listing stock targets", http.StatusInternalServerError)
+		return
+	}
+
+	app.invStateLock.RLock()
+	defer app.invStateLock.RUnlock()
+	app.writeInventoryAge(w)
+
+	httpWrite(w, app.stockShortfalls(targets))
+}
@@ -62,3 +62,10 @@ func (m *syncMap[K, V]) Data() map[K]V {
 	defer m.mu.RUnlock()
 	return maps.Clone(m.data)
 }
+
+// DeleteFunc removes every entry for which del returns true
+func (m *syncMap[K, V]) DeleteFunc(del func(k K, v V) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maps.DeleteFunc(m.data, del)
+}
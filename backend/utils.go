@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -30,6 +31,9 @@ const (
 	envEnvironment = "ENVIRONMENT"
 	envHttpPort    = "HTTP_PORT"
 	envJwtSkew     = "JWT_SKEW"
+	envEsiBasePath = "ESI_BASE_PATH"
+	envAlertHook   = "ALERT_WEBHOOK_URL"
+	envSdePath     = "SDE_PATH"
 	envDbUser      = "DB_USER"
 	envDbPass      = "DB_PASS"
 	envDbHost      = "DB_HOST"
@@ -139,13 +143,16 @@ func loadEnv(logger *zap.Logger) *runtimeConfig {
 	}
 
 	return &runtimeConfig{
-		appId:       os.Getenv(envAppId),
-		appSecret:   os.Getenv(envAppSecret),
-		appRedirect: os.Getenv(envAppRedirect),
-		environment: os.Getenv(envEnvironment),
-		migrateDown: os.Getenv(envMigrateDown),
-		httpPort:    getEnvWithDefault(envHttpPort, "2727"),
-		jwtSkew:     skew,
+		appId:        os.Getenv(envAppId),
+		appSecret:    os.Getenv(envAppSecret),
+		appRedirect:  os.Getenv(envAppRedirect),
+		environment:  os.Getenv(envEnvironment),
+		migrateDown:  os.Getenv(envMigrateDown),
+		httpPort:     getEnvWithDefault(envHttpPort, "2727"),
+		jwtSkew:      skew,
+		esiBasePath:  os.Getenv(envEsiBasePath),
+		alertWebhook: os.Getenv(envAlertHook),
+		sdePath:      os.Getenv(envSdePath),
 	}
 }
 
@@ -191,6 +198,37 @@ func httpError(w http.ResponseWriter, message string, statusCode int) {
 	fmt.Fprintf(w, `{"code":%d,"msg":"%s"}`, statusCode, message)
 }
 
+// httpErrorDetails is httpError with an additional list of details explaining the error.
+// You should return from your handler after calling this.
+func httpErrorDetails(w http.ResponseWriter, message string, statusCode int, details any) {
+	buf, err := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"msg"`
+		Details any    `json:"details,omitempty"`
+	}{statusCode, message, details})
+	if err != nil {
+		httpError(w, message, statusCode)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	w.WriteHeader(statusCode)
+	w.Write(buf)
+}
+
+// readJsonBody unmarshals the request body into v. An empty body leaves v unchanged.
+func readJsonBody(r *http.Request, v any) error {
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("error reading body: %w", err)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	return json.Unmarshal(buf, v)
+}
+
 // httpWrite converts data to a json object and writes it to http.ResponseWriter
 func httpWrite(w http.ResponseWriter, data any) {
 	buf, err := json.Marshal(data)
@@ -201,9 +239,11 @@ func httpWrite(w http.ResponseWriter, data any) {
 	w.Write(buf)
 }
 
-func (app *app) getAdminToken(logger *zap.Logger) scopeSourcePair {
-	tsps := app.dao.getTokenForCharacter(logger, app.config.AdminCharacter, []string{
+// getCorpToken returns a token of a director character with the scopes needed to poll their corp
+func (app *app) getCorpToken(logger *zap.Logger, characterId int32) scopeSourcePair {
+	tsps := app.dao.getTokenForCharacter(logger, characterId, []string{
 		string(glue.EsiScope_AssetsReadCorporationAssets_v1),
+		string(glue.EsiScope_ContractsReadCorporationContracts_v1),
 		string(glue.EsiScope_CorporationsReadBlueprints_v1),
 		string(glue.EsiScope_CorporationsReadDivisions_v1),
 		string(glue.EsiScope_IndustryReadCorporationJobs_v1),
@@ -230,3 +270,14 @@ func parseEsiError(err error) string {
 	}
 	return s["error"]
 }
+
+// parseTimeParam parses a query parameter as a date or RFC3339 timestamp, returning def if it's empty
+func parseTimeParam(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,6 +2,8 @@ import { useQuery, type UseQueryResult } from "@tanstack/react-query";
 
 export interface Blueprint {
   quantity: number;
+  reserved?: number;
+  available: number;
   runs: number;
   type_id: number;
   material_efficiency?: number;
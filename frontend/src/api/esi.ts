@@ -30,8 +30,10 @@ async function fetchNameBatch(
 
   for (let i = 0; i < ids.length; i += chunkSize) {
     const chunk = ids.slice(i, i + chunkSize);
-    const response = await fetch("https://esi.evetech.net/universe/names", {
+    // the backend resolves types from the sde and passes anything else on to esi
+    const response = await fetch("/api/names", {
       method: "POST",
+      credentials: "include",
       headers: {
         "Content-Type": "application/json",
         Accept: "application/json",
@@ -41,7 +43,7 @@ async function fetchNameBatch(
     });
 
     if (!response.ok) {
-      throw new Error(`Failed to fetch ESI names (${response.status})`);
+      throw new Error(`Failed to fetch names (${response.status})`);
     }
 
     const data: EsiName[] = await response.json();
@@ -1,11 +1,20 @@
 import {
+  useInfiniteQuery,
   useMutation,
-  useQuery,
   useQueryClient,
+  type InfiniteData,
   type QueryClient,
+  type UseInfiniteQueryResult,
   type UseMutationResult,
-  type UseQueryResult,
 } from "@tanstack/react-query";
+import { useMemo } from "react";
+
+export interface BlueprintPick {
+  runs: number;
+  me: number;
+  te: number;
+  quantity: number;
+}
 
 export interface BlueprintLineItem {
   type_id: number;
@@ -14,6 +23,8 @@ export interface BlueprintLineItem {
   time_efficiency?: number;
   quantity?: number;
   type_name: string;
+  any?: boolean;
+  picks?: BlueprintPick[];
 }
 
 export interface RequisitionLock {
@@ -31,6 +42,8 @@ export interface BlueprintRequest {
   updated_at: string;
   updated_by?: string;
   public_notes?: string;
+  private_notes?: string;
+  corporation_id?: number;
   lock?: RequisitionLock | null;
   blueprints: BlueprintLineItem[];
 }
@@ -52,22 +65,33 @@ export const requisitionsQueryKey = ["requisitions"] as const;
 interface FetchRequisitionsOptions {
   signal?: AbortSignal;
   status?: number;
+  cursor?: string;
+}
+
+export interface RequisitionsPage {
+  requisitions: BlueprintRequest[];
+  nextCursor?: string;
 }
 
-function buildRequisitionsUrl(status?: number) {
+function buildRequisitionsUrl(status?: number, cursor?: string) {
   const search = new URLSearchParams();
   if (status != null) {
     search.set("status", String(status));
   }
+  if (cursor) {
+    search.set("cursor", cursor);
+  }
   const suffix = search.toString();
   return suffix.length > 0 ? `/api/requisition?${suffix}` : "/api/requisition";
 }
 
-export async function fetchRequisitions({
+// fetch a single page, the cursor for the next one is returned in the X-Next-Cursor header
+export async function fetchRequisitionsPage({
   signal,
   status,
-}: FetchRequisitionsOptions = {}) {
-  const response = await fetch(buildRequisitionsUrl(status), {
+  cursor,
+}: FetchRequisitionsOptions = {}): Promise<RequisitionsPage> {
+  const response = await fetch(buildRequisitionsUrl(status, cursor), {
     credentials: "include",
     signal,
   });
@@ -76,20 +100,39 @@ export async function fetchRequisitions({
     throw new Error(`Failed to load requisitions (${response.status})`);
   }
 
-  const data: BlueprintRequest[] = await response.json();
-  return data;
+  const requisitions: BlueprintRequest[] = await response.json();
+  return {
+    requisitions,
+    nextCursor: response.headers.get("X-Next-Cursor") ?? undefined,
+  };
 }
 
-export function useRequisitionsQuery(
-  status?: number
-): UseQueryResult<BlueprintRequest[]> {
-  return useQuery({
+export type RequisitionsQueryResult = Omit<
+  UseInfiniteQueryResult<InfiniteData<RequisitionsPage>>,
+  "data"
+> & {
+  data: BlueprintRequest[] | undefined;
+};
+
+// loads the first page of requisitions, further pages are only fetched by fetchNextPage
+export function useRequisitionsQuery(status?: number): RequisitionsQueryResult {
+  const query = useInfiniteQuery({
     queryKey:
       status == null
         ? requisitionsQueryKey
         : ([...requisitionsQueryKey, { status }] as const),
-    queryFn: ({ signal }) => fetchRequisitions({ signal, status }),
+    queryFn: ({ signal, pageParam }) =>
+      fetchRequisitionsPage({ signal, status, cursor: pageParam }),
+    initialPageParam: undefined as string | undefined,
+    getNextPageParam: (lastPage) => lastPage.nextCursor,
   });
+
+  const data = useMemo(
+    () => query.data?.pages.flatMap((page) => page.requisitions),
+    [query.data]
+  );
+
+  return { ...query, data };
 }
 
 async function postRequisition(payload: CreateRequisitionPayload) {
@@ -13,6 +13,7 @@ function RouteComponent() {
     data: openRequisitions = [],
     isLoading: areRequisitionsLoading,
     error: requisitionsError,
+    hasNextPage: moreRequisitions,
   } = useRequisitionsQuery(0);
 
   const {
@@ -61,7 +62,7 @@ function RouteComponent() {
     return { uniqueTypeCount: typeIds.size, totalQuantity: quantity };
   }, [blueprintGroups]);
 
-  const renderCount = (count: number, isLoading: boolean) => {
+  const renderCount = (count: number | string, isLoading: boolean) => {
     if (isLoading) {
       return <Skeleton className="h-7 w-16 rounded" />;
     }
@@ -85,7 +86,11 @@ function RouteComponent() {
             <span className="text-sm uppercase text-default-500">
               Open Requisitions
             </span>
-            {renderCount(openRequisitions.length, areRequisitionsLoading)}
+            {renderCount(
+              // only the first page is loaded
+              `${openRequisitions.length}${moreRequisitions ? "+" : ""}`,
+              areRequisitionsLoading
+            )}
           </CardHeader>
           <CardBody className="text-sm text-default-600">
             {areRequisitionsLoading
@@ -131,6 +131,9 @@ function RouteComponent() {
     isLoading,
     error,
     refetch,
+    hasNextPage,
+    fetchNextPage,
+    isFetchingNextPage,
   } = useRequisitionsQuery(statusFilter);
 
   const [selectedKey, setSelectedKey] = useState<number | null>(null);
@@ -548,6 +551,18 @@ function RouteComponent() {
             />
           </div>
         </div>
+
+        {hasNextPage && (
+          <div className="flex justify-center">
+            <Button
+              isLoading={isFetchingNextPage}
+              onPress={() => void fetchNextPage()}
+              variant="flat"
+            >
+              Load more
+            </Button>
+          </div>
+        )}
       </div>
 
       {selectedRequest && (